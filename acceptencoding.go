@@ -0,0 +1,179 @@
+package gzip
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// content-codings with special meaning in Accept-Encoding
+const (
+	encodingIdentity = "identity"
+	encodingAny      = "*"
+)
+
+// AcceptedEncoding is a content-coding listed in Accept-Encoding header
+type AcceptedEncoding struct {
+	// Coding is the content-coding token in lower case,
+	// which can also be "*" or "identity"
+	Coding string
+	// Q is the quality value ranged from 0 to 1,
+	// 0 means not acceptable
+	Q float64
+}
+
+// AcceptEncoding is the parsed Accept-Encoding header,
+// ranked by q-value in descending order.
+//
+// see https://www.rfc-editor.org/rfc/rfc9110#field.accept-encoding
+type AcceptEncoding []AcceptedEncoding
+
+// ParseAcceptEncoding parses Accept-Encoding in header.
+//
+// Codings are ranked by q-value in descending order,
+// codings of the same q-value keep their order in header.
+// Malformed entries are ignored,
+// "x-gzip" and "x-compress" are treated as "gzip" and "compress".
+func ParseAcceptEncoding(header http.Header) AcceptEncoding {
+	var accepted AcceptEncoding
+
+	for _, value := range header["Accept-Encoding"] {
+		for _, item := range strings.Split(value, ",") {
+			if encoding, ok := parseAcceptedEncoding(item); ok {
+				accepted = append(accepted, encoding)
+			}
+		}
+	}
+
+	sort.SliceStable(accepted, func(i, j int) bool {
+		return accepted[i].Q > accepted[j].Q
+	})
+
+	return accepted
+}
+
+func parseAcceptedEncoding(item string) (encoding AcceptedEncoding, ok bool) {
+	params := strings.Split(item, ";")
+
+	encoding.Coding = strings.ToLower(strings.TrimSpace(params[0]))
+	encoding.Q = 1
+	if encoding.Coding == "" || strings.ContainsAny(encoding.Coding, " \t") {
+		return encoding, false
+	}
+	switch encoding.Coding {
+	case "x-gzip":
+		encoding.Coding = "gzip"
+	case "x-compress":
+		encoding.Coding = "compress"
+	}
+
+	for _, param := range params[1:] {
+		name, value, found := cutString(strings.TrimSpace(param), "=")
+		if !found {
+			return encoding, false
+		}
+		if !strings.EqualFold(strings.TrimSpace(name), "q") {
+			// other parameters are not defined for Accept-Encoding
+			continue
+		}
+
+		q, err := parseQValue(strings.TrimSpace(value))
+		if err != nil {
+			return encoding, false
+		}
+		encoding.Q = q
+	}
+
+	return encoding, true
+}
+
+// parseQValue parses qvalue = ( "0" [ "." 0*3DIGIT ] ) / ( "1" [ "." 0*3("0") ] )
+func parseQValue(value string) (float64, error) {
+	if value == "" || len(value) > 5 || (value[0] != '0' && value[0] != '1') ||
+		(len(value) > 1 && value[1] != '.') {
+		return 0, strconv.ErrSyntax
+	}
+
+	q, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, err
+	}
+	if q > 1 {
+		return 0, strconv.ErrRange
+	}
+
+	return q, nil
+}
+
+// cutString is strings.Cut before Go 1.18
+func cutString(s, sep string) (before, after string, found bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+// Quality returns the q-value of coding,
+// 0 means coding is not acceptable.
+//
+// An explicitly listed coding takes its own q-value,
+// otherwise "*" applies if present.
+// "identity" is always acceptable unless excluded by q=0.
+func (a AcceptEncoding) Quality(coding string) float64 {
+	coding = strings.ToLower(coding)
+
+	var (
+		anyQ     float64
+		anyFound bool
+	)
+	for _, item := range a {
+		if item.Coding == coding {
+			return item.Q
+		}
+		if item.Coding == encodingAny && !anyFound {
+			anyQ, anyFound = item.Q, true
+		}
+	}
+
+	if anyFound {
+		return anyQ
+	}
+	if coding == encodingIdentity {
+		return 1
+	}
+
+	return 0
+}
+
+// AcceptsCompression tells whether any content-coding other than "identity"
+// may be acceptable.
+func (a AcceptEncoding) AcceptsCompression() bool {
+	for _, item := range a {
+		if item.Coding != encodingIdentity && item.Q > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Negotiate picks the acceptable content-coding with the highest q-value from
+// supported, which are in server's order of preference,
+// so ties are broken by supported.
+//
+// Empty string is returned if none in supported is acceptable.
+func (a AcceptEncoding) Negotiate(supported []string) string {
+	var (
+		chosen  string
+		chosenQ float64
+	)
+
+	for _, coding := range supported {
+		if q := a.Quality(coding); q > chosenQ {
+			chosen, chosenQ = coding, q
+		}
+	}
+
+	return chosen
+}
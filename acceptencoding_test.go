@@ -0,0 +1,136 @@
+package gzip
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func acceptEncodingHeader(values ...string) http.Header {
+	return http.Header{"Accept-Encoding": values}
+}
+
+func TestParseAcceptEncoding(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		want   AcceptEncoding
+	}{
+		{
+			name:   "absent",
+			header: make(http.Header),
+			want:   nil,
+		},
+		{
+			name:   "single",
+			header: acceptEncodingHeader("gzip"),
+			want:   AcceptEncoding{{"gzip", 1}},
+		},
+		{
+			name:   "ranked",
+			header: acceptEncodingHeader("gzip;q=0.5, deflate; q=0.8 , br"),
+			want:   AcceptEncoding{{"br", 1}, {"deflate", 0.8}, {"gzip", 0.5}},
+		},
+		{
+			name:   "ties keep order",
+			header: acceptEncodingHeader("deflate, gzip;q=1.0, *;q=0"),
+			want:   AcceptEncoding{{"deflate", 1}, {"gzip", 1}, {"*", 0}},
+		},
+		{
+			name:   "multiple lines",
+			header: acceptEncodingHeader("gzip;q=0.1", "zstd"),
+			want:   AcceptEncoding{{"zstd", 1}, {"gzip", 0.1}},
+		},
+		{
+			name:   "case and alias",
+			header: acceptEncodingHeader("X-GZIP;Q=0.3, Identity"),
+			want:   AcceptEncoding{{"identity", 1}, {"gzip", 0.3}},
+		},
+		{
+			name:   "malformed",
+			header: acceptEncodingHeader("gzip;q=2, br;q=0.1234, deflate;q, zstd;q=.5, , compress;q=0.25"),
+			want:   AcceptEncoding{{"compress", 0.25}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, ParseAcceptEncoding(tt.header))
+		})
+	}
+}
+
+func TestAcceptEncoding_Quality(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		coding         string
+		want           float64
+	}{
+		{"gzip", "gzip", 1},
+		{"gzip", "GZIP", 1},
+		{"gzip", "br", 0},
+		{"gzip;q=0", "gzip", 0},
+		{"x-gzip;q=0.5", "gzip", 0.5},
+		{"*", "br", 1},
+		{"*;q=0.2, gzip", "br", 0.2},
+		{"*, gzip;q=0", "gzip", 0},
+		{"gzip", "identity", 1},
+		{"*;q=0", "identity", 0},
+		{"*;q=0, identity", "identity", 1},
+		{"identity;q=0", "identity", 0},
+		{"xgzip", "gzip", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.acceptEncoding+"/"+tt.coding, func(t *testing.T) {
+			assert.Equal(t, tt.want, ParseAcceptEncoding(acceptEncodingHeader(tt.acceptEncoding)).Quality(tt.coding))
+		})
+	}
+}
+
+func TestAcceptEncoding_AcceptsCompression(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		want           bool
+	}{
+		{"", false},
+		{"identity", false},
+		{"gzip;q=0", false},
+		{"*;q=0", false},
+		{"gzip", true},
+		{"*", true},
+		{"identity, deflate;q=0.1", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.acceptEncoding, func(t *testing.T) {
+			assert.Equal(t, tt.want, ParseAcceptEncoding(acceptEncodingHeader(tt.acceptEncoding)).AcceptsCompression())
+		})
+	}
+}
+
+func TestAcceptEncoding_Negotiate(t *testing.T) {
+	supported := []string{encodingZstd, encodingBrotli, encodingGzip, "deflate"}
+
+	tests := []struct {
+		acceptEncoding string
+		want           string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"gzip", "gzip"},
+		{"x-gzip", "gzip"},
+		{"deflate", "deflate"},
+		{"gzip, deflate, br", "br"},
+		{"gzip, deflate, br, zstd", "zstd"},
+		{"gzip;q=1, br;q=0.9", "gzip"},
+		{"gzip;q=0, deflate;q=0.1", "deflate"},
+		{"*", "zstd"},
+		{"*, zstd;q=0, br;q=0", "gzip"},
+		{"*;q=0", ""},
+		{"xgzip, brotli", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.acceptEncoding, func(t *testing.T) {
+			assert.Equal(t, tt.want, ParseAcceptEncoding(acceptEncodingHeader(tt.acceptEncoding)).Negotiate(supported))
+		})
+	}
+}
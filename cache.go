@@ -0,0 +1,650 @@
+package gzip
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache keeps compressed responses in memory, so that later requests
+// of them bypass compression entirely.
+//
+// Compressed 200 responses of GET requests are cached if they carry
+// ETag or Last-Modified, with which the handler is asked to validate
+// a cached response before it is served.
+// Responses setting cookies, marked no-store or private, or varying on "*"
+// are never cached. A response varying on request headers other than
+// Accept-Encoding is served only to requests with the same values of them,
+// use CacheVaryKey to cache its variants side by side.
+//
+// Requests carrying Authorization, or Cookie not covered by CacheVaryKey,
+// are personal, their responses are cached and served from cache only if
+// marked public, s-maxage or must-revalidate.
+//
+// see https://www.rfc-editor.org/rfc/rfc9111#section-3.5
+//
+// Cache evicts least recently used responses to stay within its byte budget.
+// Responses larger than the entry limit are not cached,
+// and are not buffered beyond it while being compressed,
+// see SetMaxEntryBytes().
+//
+// It's safe for concurrent use and can be shared by Handlers.
+type Cache struct {
+	maxBytes int64
+	ttl      time.Duration
+
+	mu sync.Mutex
+	// max size of a cached response
+	maxEntryBytes int64
+	size          int64
+	entries       map[string]*list.Element
+	// front is the most recently used
+	lru    *list.List
+	hits   uint64
+	misses uint64
+}
+
+// CacheStats are statistics of Cache
+type CacheStats struct {
+	// Hits is the count of requests served by Cache
+	Hits uint64
+	// Misses is the count of requests not found in Cache,
+	// or whose cached responses are expired or invalidated by the handler.
+	Misses uint64
+	// Entries is the count of cached responses
+	Entries int
+	// Bytes is the approximate size of cached responses
+	Bytes int64
+}
+
+type cacheEntry struct {
+	key string
+	// request target, i.e. path and query
+	target string
+	// header of compressed response
+	header http.Header
+	// compressed body
+	body []byte
+	// validators of the original response
+	etag         string
+	lastModified string
+	// request values of fields the response varies on,
+	// other than Accept-Encoding
+	vary map[string]string
+	// whether response may be shared with personal requests
+	shared  bool
+	expires time.Time
+	size    int64
+}
+
+// NewCache returns a Cache keeping at most maxBytes of responses,
+// each for at most ttl.
+//
+// A response is cached only if it's within maxBytes/16,
+// which can be changed by SetMaxEntryBytes().
+//
+// Zero ttl means responses do not expire, although they are still
+// validated by the handler.
+func NewCache(maxBytes int64, ttl time.Duration) *Cache {
+	if maxBytes <= 0 {
+		panic(fmt.Sprintf("gzip: invalid cache maxBytes: %d", maxBytes))
+	}
+	if ttl < 0 {
+		panic(fmt.Sprintf("gzip: invalid cache ttl: %s", ttl))
+	}
+
+	maxEntryBytes := maxBytes / defaultEntriesPerCache
+	if maxEntryBytes == 0 {
+		maxEntryBytes = 1
+	}
+
+	return &Cache{
+		maxBytes:      maxBytes,
+		ttl:           ttl,
+		maxEntryBytes: maxEntryBytes,
+		entries:       make(map[string]*list.Element),
+		lru:           list.New(),
+	}
+}
+
+// defaultEntriesPerCache decides the default entry limit of Cache,
+// which bounds the memory every in-flight response takes for caching.
+const defaultEntriesPerCache = 16
+
+// SetMaxEntryBytes sets the max size of a cached response,
+// valid value: 1 => maxBytes of NewCache().
+//
+// Every in-flight compressed response may buffer up to maxEntryBytes
+// for caching, so keep it small.
+func (c *Cache) SetMaxEntryBytes(maxEntryBytes int64) {
+	if maxEntryBytes <= 0 || maxEntryBytes > c.maxBytes {
+		panic(fmt.Sprintf("gzip: invalid cache maxEntryBytes: %d", maxEntryBytes))
+	}
+
+	c.mu.Lock()
+	c.maxEntryBytes = maxEntryBytes
+	c.mu.Unlock()
+}
+
+// entryLimit returns the max size of a cached response
+func (c *Cache) entryLimit() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.maxEntryBytes
+}
+
+// Invalidate removes cached responses of target,
+// which is the path and query of request, e.g. "/api/users?page=1",
+// regardless of host, vary key and content-coding.
+//
+// The count of removed responses is returned.
+func (c *Cache) Invalidate(target string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	removed := 0
+	for element := c.lru.Front(); element != nil; {
+		next := element.Next()
+		if element.Value.(*cacheEntry).target == target {
+			c.remove(element)
+			removed++
+		}
+		element = next
+	}
+
+	return removed
+}
+
+// Purge removes all cached responses
+func (c *Cache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+	c.size = 0
+}
+
+// Stats returns statistics of c
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return CacheStats{
+		Hits:    c.hits,
+		Misses:  c.misses,
+		Entries: len(c.entries),
+		Bytes:   c.size,
+	}
+}
+
+// get returns the cached response of key,
+// misses are counted here while hits are counted by hit()
+// as they depends on validation.
+func (c *Cache) get(key string) (*cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+
+	entry := element.Value.(*cacheEntry)
+	if c.ttl > 0 && time.Now().After(entry.expires) {
+		c.remove(element)
+		c.misses++
+		return nil, false
+	}
+
+	c.lru.MoveToFront(element)
+	return entry, true
+}
+
+func (c *Cache) hit() {
+	c.mu.Lock()
+	c.hits++
+	c.mu.Unlock()
+}
+
+func (c *Cache) miss() {
+	c.mu.Lock()
+	c.misses++
+	c.mu.Unlock()
+}
+
+func (c *Cache) set(entry *cacheEntry) {
+	entry.size = int64(len(entry.key) + len(entry.body))
+	for key, values := range entry.header {
+		entry.size += int64(len(key))
+		for _, value := range values {
+			entry.size += int64(len(value))
+		}
+	}
+	for key, value := range entry.vary {
+		entry.size += int64(len(key) + len(value))
+	}
+	if c.ttl > 0 {
+		entry.expires = time.Now().Add(c.ttl)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry.size > c.maxEntryBytes {
+		return
+	}
+
+	if element, ok := c.entries[entry.key]; ok {
+		c.remove(element)
+	}
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	c.size += entry.size
+
+	for c.size > c.maxBytes {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove must be called with mu held
+func (c *Cache) remove(element *list.Element) {
+	entry := c.lru.Remove(element).(*cacheEntry)
+	delete(c.entries, entry.key)
+	c.size -= entry.size
+}
+
+// cacheable tells whether compressed response of header can be cached
+func cacheable(header http.Header) bool {
+	if header.Get("ETag") == "" && header.Get("Last-Modified") == "" {
+		return false
+	}
+	if _, ok := header["Set-Cookie"]; ok {
+		return false
+	}
+
+	cacheControl := strings.ToLower(strings.Join(header["Cache-Control"], ","))
+	if strings.Contains(cacheControl, "no-store") || strings.Contains(cacheControl, "private") {
+		return false
+	}
+
+	for _, vary := range header["Vary"] {
+		if strings.Contains(vary, "*") {
+			return false
+		}
+	}
+
+	return true
+}
+
+// varyValues returns values in reqHeader of fields
+// listed by Vary of header, Accept-Encoding is left out
+// as content-coding is part of cache key.
+func varyValues(header, reqHeader http.Header) map[string]string {
+	var values map[string]string
+	for _, vary := range header["Vary"] {
+		for vary != "" {
+			var field string
+			field, vary, _ = cutString(vary, ",")
+			field = http.CanonicalHeaderKey(strings.TrimSpace(field))
+			if field == "" || field == "Accept-Encoding" {
+				continue
+			}
+			if values == nil {
+				values = make(map[string]string)
+			}
+			values[field] = strings.Join(reqHeader[field], ",")
+		}
+	}
+
+	return values
+}
+
+// matches tells whether req agrees with the request of e
+// on fields the response varies on.
+func (e *cacheEntry) matches(req *http.Request) bool {
+	for field, value := range e.vary {
+		if strings.Join(req.Header[field], ",") != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// sharedCacheable tells whether compressed response of header
+// may be cached for, and served to, personal requests.
+func sharedCacheable(header http.Header) bool {
+	for _, value := range header["Cache-Control"] {
+		for value != "" {
+			var directive string
+			directive, value, _ = cutString(value, ",")
+			directive, _, _ = cutString(directive, "=")
+			switch strings.ToLower(strings.TrimSpace(directive)) {
+			case "public", "s-maxage", "must-revalidate":
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// cacheSink collects compressed response for Cache,
+// giving up once it's beyond limit.
+type cacheSink struct {
+	buf      bytes.Buffer
+	limit    int64
+	overflow bool
+}
+
+// cacheSinkKeepSize is the max capacity of buffer
+// a cacheSink keeps for reuse, larger ones are dropped
+// so that they are not held by pooled writerWrapper.
+const cacheSinkKeepSize = 64 << 10
+
+func (s *cacheSink) Reset(limit int64) {
+	if s.buf.Cap() > cacheSinkKeepSize {
+		s.buf = bytes.Buffer{}
+	} else {
+		s.buf.Reset()
+	}
+	s.limit = limit
+	s.overflow = false
+}
+
+// Write never fails, so that it does not break the response
+func (s *cacheSink) Write(data []byte) (int, error) {
+	if s.overflow {
+		return len(data), nil
+	}
+	if int64(s.buf.Len()+len(data)) > s.limit {
+		s.overflow = true
+		s.buf = bytes.Buffer{}
+		return len(data), nil
+	}
+
+	return s.buf.Write(data)
+}
+
+// teeWriter writes what's written into w to sink as well,
+// sink must not fail.
+type teeWriter struct {
+	w    io.Writer
+	sink io.Writer
+}
+
+func (t teeWriter) Write(data []byte) (int, error) {
+	written, err := t.w.Write(data)
+	_, _ = t.sink.Write(data[:written])
+	return written, err
+}
+
+// validationWriter sits between writerWrapper and the original writer
+// when a cached response is found, swallowing the 304 with which
+// the handler validates the cached response.
+//
+// Optional interfaces of the original writer are forwarded,
+// whether they are exposed is decided by the original writer.
+type validationWriter struct {
+	http.ResponseWriter
+	validated bool
+}
+
+// WriteHeader implements http.ResponseWriter
+func (v *validationWriter) WriteHeader(statusCode int) {
+	if statusCode == http.StatusNotModified {
+		v.validated = true
+		return
+	}
+
+	v.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Write implements http.ResponseWriter
+func (v *validationWriter) Write(data []byte) (int, error) {
+	if v.validated {
+		return len(data), nil
+	}
+
+	return v.ResponseWriter.Write(data)
+}
+
+// Flush implements http.Flusher
+func (v *validationWriter) Flush() {
+	_ = v.FlushError()
+}
+
+// FlushError is Flush() which reports error of the original writer
+func (v *validationWriter) FlushError() error {
+	if v.validated {
+		return nil
+	}
+
+	return flushError(v.ResponseWriter)
+}
+
+// Hijack implements http.Hijacker
+func (v *validationWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := v.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("gzip: original http.ResponseWriter is not a http.Hijacker")
+	}
+
+	return hijacker.Hijack()
+}
+
+// Push implements http.Pusher
+func (v *validationWriter) Push(target string, opts *http.PushOptions) error {
+	pusher, ok := v.ResponseWriter.(http.Pusher)
+	if !ok {
+		return http.ErrNotSupported
+	}
+
+	return pusher.Push(target, opts)
+}
+
+// Unwrap returns the original http.ResponseWriter
+func (v *validationWriter) Unwrap() http.ResponseWriter {
+	return v.ResponseWriter
+}
+
+// cacheHit is a cached response pending validation of the handler
+type cacheHit struct {
+	cache  *Cache
+	entry  *cacheEntry
+	writer *validationWriter
+	// header of original writer before handler runs
+	header http.Header
+	// request of client
+	req *http.Request
+	// conditional request to validate entry
+	validationReq *http.Request
+}
+
+// validatingHeaders are conditional headers of client,
+// which are evaluated against cached response instead of the handler.
+var validatingHeaders = []string{"If-Match", "If-None-Match", "If-Modified-Since", "If-Unmodified-Since", "If-Range", "Range"}
+
+// personal tells whether req carries credentials,
+// i.e. Authorization, or Cookie not covered by CacheVaryKey.
+func (h *Handler) personal(req *http.Request) bool {
+	if req.Header.Get("Authorization") != "" {
+		return true
+	}
+	if req.Header.Get("Cookie") == "" {
+		return false
+	}
+	if h.cacheVaryKey == nil {
+		return true
+	}
+
+	// CacheVaryKey covers Cookie if it tells req apart from the one without Cookie
+	anonymous := new(http.Request)
+	*anonymous = *req
+	anonymous.Header = req.Header.Clone()
+	anonymous.Header.Del("Cookie")
+
+	return h.cacheVaryKey(req) == h.cacheVaryKey(anonymous)
+}
+
+// cacheKey returns key of req in Cache
+func (h *Handler) cacheKey(req *http.Request, encoding string, overrideEncodings []string) string {
+	var key strings.Builder
+	key.WriteString(req.Method)
+	key.WriteByte(' ')
+	key.WriteString(req.Host)
+	key.WriteString(req.URL.RequestURI())
+	key.WriteByte('\n')
+	if h.cacheVaryKey != nil {
+		key.WriteString(h.cacheVaryKey(req))
+	}
+	key.WriteByte('\n')
+	key.WriteString(encoding)
+	for _, override := range overrideEncodings {
+		key.WriteByte(',')
+		key.WriteString(override)
+	}
+
+	return key.String()
+}
+
+// prepareCache looks up Cache for req,
+// the response of which is to be stored in Cache by wrapper.
+//
+// On a hit, wrapper writes through a validationWriter,
+// and the handler should serve the returned cacheHit.validationReq,
+// after which cacheHit.serve() is called.
+func (h *Handler) prepareCache(wrapper *writerWrapper, req *http.Request, encoding string, overrideEncodings []string) *cacheHit {
+	if h.cache == nil || req.Method != http.MethodGet {
+		return nil
+	}
+	// ranges of uncompressed body are left to handler
+	if h.rangePolicy != RangeServeCompressed && req.Header.Get("Range") != "" {
+		return nil
+	}
+
+	key := h.cacheKey(req, encoding, overrideEncodings)
+	wrapper.cache = h.cache
+	wrapper.cacheKey = key
+	wrapper.cacheTarget = req.URL.RequestURI()
+	wrapper.cachePersonal = h.personal(req)
+
+	entry, ok := h.cache.get(key)
+	if !ok {
+		return nil
+	}
+	if wrapper.cachePersonal && !entry.shared || !entry.matches(req) {
+		h.cache.miss()
+		return nil
+	}
+
+	writer := &validationWriter{ResponseWriter: wrapper.OriginWriter}
+	wrapper.OriginWriter = writer
+
+	validationReq := new(http.Request)
+	*validationReq = *req
+	validationReq.Header = req.Header.Clone()
+	for _, key := range validatingHeaders {
+		validationReq.Header.Del(key)
+	}
+	if entry.etag != "" {
+		validationReq.Header.Set("If-None-Match", entry.etag)
+	} else {
+		validationReq.Header.Set("If-Modified-Since", entry.lastModified)
+	}
+
+	return &cacheHit{
+		cache:         h.cache,
+		entry:         entry,
+		writer:        writer,
+		header:        writer.Header().Clone(),
+		req:           req,
+		validationReq: validationReq,
+	}
+}
+
+// serve serves the cached response if the handler validates it
+func (c *cacheHit) serve() {
+	if !c.writer.validated {
+		c.cache.miss()
+		return
+	}
+	c.cache.hit()
+
+	origin := c.writer.ResponseWriter
+	header := origin.Header()
+	for key := range header {
+		delete(header, key)
+	}
+	for key, values := range c.header {
+		header[key] = values
+	}
+	for key, values := range c.entry.header {
+		header[key] = values
+	}
+
+	var modTime time.Time
+	if c.entry.lastModified != "" {
+		modTime, _ = http.ParseTime(c.entry.lastModified)
+	}
+
+	if c.req.Header.Get("Range") != "" {
+		origin = partialContentWriter{ResponseWriter: origin}
+	}
+
+	http.ServeContent(origin, c.req, "", modTime, bytes.NewReader(c.entry.body))
+}
+
+// partialContentWriter drops Content-Digest of the whole body
+// when http.ServeContent responds with ranges of it.
+type partialContentWriter struct {
+	http.ResponseWriter
+}
+
+// WriteHeader implements http.ResponseWriter
+func (p partialContentWriter) WriteHeader(statusCode int) {
+	if statusCode == http.StatusPartialContent {
+		p.Header().Del("Content-Digest")
+	}
+
+	p.ResponseWriter.WriteHeader(statusCode)
+}
+
+// storeCache puts the compressed response into Cache
+func (w *writerWrapper) storeCache() {
+	if w.cacheHeader == nil || w.cacheSink.overflow ||
+		w.statusCode != http.StatusOK || w.streaming || !cacheable(w.cacheHeader) {
+		return
+	}
+
+	shared := sharedCacheable(w.cacheHeader)
+	if w.cachePersonal && !shared {
+		return
+	}
+
+	header := w.cacheHeader
+	header.Del("Content-Length")
+	header.Del("Trailer")
+	header.Del("Date")
+
+	w.cache.set(&cacheEntry{
+		key:          w.cacheKey,
+		target:       w.cacheTarget,
+		header:       header,
+		body:         append([]byte(nil), w.cacheSink.buf.Bytes()...),
+		etag:         w.cacheETag,
+		lastModified: header.Get("Last-Modified"),
+		vary:         varyValues(header, w.req.Header),
+		shared:       shared,
+	})
+}
@@ -0,0 +1,590 @@
+package gzip
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newCacheEntry(key, target string, size int) *cacheEntry {
+	return &cacheEntry{
+		key:    key,
+		target: target,
+		body:   make([]byte, size-len(key)),
+	}
+}
+
+func TestNewCache_Checks(t *testing.T) {
+	assert.NotPanics(t, func() {
+		NewCache(1024, 0)
+	})
+	assert.Panics(t, func() {
+		NewCache(0, time.Minute)
+	})
+	assert.Panics(t, func() {
+		NewCache(1024, -time.Minute)
+	})
+	assert.Panics(t, func() {
+		NewCache(1024, 0).SetMaxEntryBytes(0)
+	})
+	assert.Panics(t, func() {
+		NewCache(1024, 0).SetMaxEntryBytes(1025)
+	})
+}
+
+func TestCache_LRU(t *testing.T) {
+	cache := NewCache(300, 0)
+	cache.SetMaxEntryBytes(300)
+
+	cache.set(newCacheEntry("a", "/a", 100))
+	cache.set(newCacheEntry("b", "/b", 100))
+	cache.set(newCacheEntry("c", "/c", 100))
+
+	// a is the most recently used now
+	_, ok := cache.get("a")
+	require.True(t, ok)
+
+	cache.set(newCacheEntry("d", "/d", 100))
+
+	_, ok = cache.get("b")
+	assert.False(t, ok)
+	for _, key := range []string{"a", "c", "d"} {
+		_, ok = cache.get(key)
+		assert.True(t, ok, key)
+	}
+
+	// too large
+	cache.set(newCacheEntry("e", "/e", 301))
+	_, ok = cache.get("e")
+	assert.False(t, ok)
+
+	assert.Equal(t, CacheStats{
+		Hits:    0,
+		Misses:  2,
+		Entries: 3,
+		Bytes:   300,
+	}, cache.Stats())
+}
+
+func TestCache_TTL(t *testing.T) {
+	cache := NewCache(1<<20, 10*time.Millisecond)
+
+	cache.set(newCacheEntry("a", "/a", 100))
+	_, ok := cache.get("a")
+	require.True(t, ok)
+
+	time.Sleep(20 * time.Millisecond)
+	_, ok = cache.get("a")
+	assert.False(t, ok)
+	assert.Zero(t, cache.Stats().Entries)
+}
+
+func TestCache_Invalidate(t *testing.T) {
+	cache := NewCache(1<<20, 0)
+
+	cache.set(newCacheEntry("a gzip", "/a", 100))
+	cache.set(newCacheEntry("a br", "/a", 100))
+	cache.set(newCacheEntry("b", "/b", 100))
+
+	assert.Equal(t, 2, cache.Invalidate("/a"))
+	assert.Equal(t, 0, cache.Invalidate("/a"))
+	assert.Equal(t, CacheStats{Entries: 1, Bytes: 100}, cache.Stats())
+
+	cache.Purge()
+	assert.Equal(t, CacheStats{}, cache.Stats())
+}
+
+func Test_cacheSink(t *testing.T) {
+	var sink cacheSink
+	sink.Reset(10)
+
+	_, _ = sink.Write([]byte("hello"))
+	assert.Equal(t, "hello", sink.buf.String())
+	_, _ = sink.Write([]byte("world!"))
+	assert.True(t, sink.overflow)
+	assert.Zero(t, sink.buf.Cap())
+
+	// large buffer is not kept for reuse
+	sink.Reset(1 << 20)
+	_, _ = sink.Write(make([]byte, cacheSinkKeepSize+1))
+	sink.Reset(1 << 20)
+	assert.False(t, sink.overflow)
+	assert.Zero(t, sink.buf.Cap())
+}
+
+func Test_cacheable(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		want   bool
+	}{
+		{"etag", http.Header{"Etag": []string{`"abc"`}}, true},
+		{"last modified", http.Header{"Last-Modified": []string{"Fri, 01 Jan 2021 00:00:00 GMT"}}, true},
+		{"no validator", http.Header{}, false},
+		{"cookie", http.Header{"Etag": []string{`"abc"`}, "Set-Cookie": []string{"a=b"}}, false},
+		{"no-store", http.Header{"Etag": []string{`"abc"`}, "Cache-Control": []string{"No-Store"}}, false},
+		{"private", http.Header{"Etag": []string{`"abc"`}, "Cache-Control": []string{"max-age=60, private"}}, false},
+		{"public", http.Header{"Etag": []string{`"abc"`}, "Cache-Control": []string{"max-age=60"}}, true},
+		{"vary any", http.Header{"Etag": []string{`"abc"`}, "Vary": []string{"*"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, cacheable(tt.header))
+		})
+	}
+}
+
+func Test_varyValues(t *testing.T) {
+	reqHeader := http.Header{"Accept-Language": []string{"en", "fr"}, "Accept-Encoding": []string{"gzip"}}
+	tests := []struct {
+		name string
+		vary []string
+		want map[string]string
+	}{
+		{"none", nil, nil},
+		{"accept-encoding", []string{"Accept-Encoding"}, nil},
+		{"fields", []string{"accept-encoding, accept-language", "Cookie"}, map[string]string{"Accept-Language": "en,fr", "Cookie": ""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, varyValues(http.Header{"Vary": tt.vary}, reqHeader))
+		})
+	}
+}
+
+func Test_sharedCacheable(t *testing.T) {
+	tests := []struct {
+		name         string
+		cacheControl []string
+		want         bool
+	}{
+		{"none", nil, false},
+		{"max-age", []string{"max-age=60"}, false},
+		{"public", []string{"Public"}, true},
+		{"s-maxage", []string{"max-age=0, s-maxage=60"}, true},
+		{"must-revalidate", []string{"no-cache", "must-revalidate"}, true},
+		{"proxy-revalidate", []string{"proxy-revalidate"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, sharedCacheable(http.Header{"Cache-Control": tt.cacheControl}))
+		})
+	}
+}
+
+// cachedInstance serves payload with etag,
+// counting requests the handler serves in full.
+type cachedInstance struct {
+	etag    string
+	payload []byte
+	full    int
+}
+
+func (c *cachedInstance) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("ETag", c.etag)
+	if r.Header.Get("If-None-Match") == c.etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	c.full++
+	_, _ = w.Write(c.payload)
+}
+
+func decompressCached(t *testing.T, body []byte) string {
+	reader, err := gzip.NewReader(bytes.NewReader(body))
+	require.NoError(t, err)
+	uncompressed, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	return string(uncompressed)
+}
+
+func TestHTTPWithCache(t *testing.T) {
+	cache := NewCache(1<<20, time.Minute)
+	config := defaultConfig
+	config.Cache = cache
+	instance := &cachedInstance{etag: `"v1"`, payload: bigPayload}
+	handler := NewHandler(config).WrapHandler(instance)
+
+	serve := func(header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/speech", nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		for key, values := range header {
+			r.Header[key] = values
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve(nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	compressed := w.Body.Bytes()
+	assert.Equal(t, 1, instance.full)
+	assert.Equal(t, CacheStats{Misses: 1, Entries: 1, Bytes: cache.Stats().Bytes}, cache.Stats())
+
+	// served from cache after validated by handler
+	w = serve(nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, `W/"v1"`, w.Header().Get("ETag"))
+	assert.Equal(t, "text/plain; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, compressed, w.Body.Bytes())
+	assert.Equal(t, 1, instance.full)
+	assert.EqualValues(t, 1, cache.Stats().Hits)
+
+	// conditional request of client is evaluated against cache
+	w = serve(http.Header{"If-None-Match": []string{`W/"v1"`}})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Body.Bytes())
+	assert.EqualValues(t, 2, cache.Stats().Hits)
+
+	// other content-coding is cached on its own
+	r := httptest.NewRequest(http.MethodGet, "/speech", nil)
+	r.Header.Set("Accept-Encoding", "br")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, "br", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, 2, instance.full)
+	assert.Equal(t, 2, cache.Stats().Entries)
+
+	// handler invalidates cached response
+	instance.etag = `"v2"`
+	instance.payload = []byte(strings.Repeat("changed ", 200))
+	w = serve(nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `W/"v2"`, w.Header().Get("ETag"))
+	assert.Equal(t, string(instance.payload), decompressCached(t, w.Body.Bytes()))
+	assert.Equal(t, 3, instance.full)
+	assert.EqualValues(t, 3, cache.Stats().Misses)
+
+	w = serve(nil)
+	assert.Equal(t, string(instance.payload), decompressCached(t, w.Body.Bytes()))
+	assert.Equal(t, 3, instance.full)
+	assert.EqualValues(t, 3, cache.Stats().Hits)
+
+	// explicit invalidation
+	assert.Equal(t, 2, cache.Invalidate("/speech"))
+	w = serve(nil)
+	assert.Equal(t, string(instance.payload), decompressCached(t, w.Body.Bytes()))
+	assert.Equal(t, 4, instance.full)
+}
+
+func TestHTTPWithCache_VaryKey(t *testing.T) {
+	cache := NewCache(1<<20, 0)
+	config := defaultConfig
+	config.Cache = cache
+	config.CacheVaryKey = func(req *http.Request) string {
+		return req.Header.Get("Accept-Language")
+	}
+	instance := &cachedInstance{etag: `"v1"`, payload: bigPayload}
+	handler := NewHandler(config).WrapHandler(instance)
+
+	for _, language := range []string{"en", "fr", "en"} {
+		r := httptest.NewRequest(http.MethodGet, "/speech", nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		r.Header.Set("Accept-Language", language)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		assert.Equal(t, string(bigPayload), decompressCached(t, w.Body.Bytes()))
+	}
+
+	assert.Equal(t, 2, instance.full)
+	assert.Equal(t, CacheStats{Hits: 1, Misses: 2, Entries: 2, Bytes: cache.Stats().Bytes}, cache.Stats())
+}
+
+func TestHTTPWithCache_Vary(t *testing.T) {
+	cache := NewCache(1<<20, 0)
+	config := defaultConfig
+	config.Cache = cache
+	lastModified := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	full := 0
+	handler := NewHandler(config).WrapHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Vary", "Accept-Language")
+		w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+		if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !lastModified.After(since) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		full++
+		_, _ = w.Write(bytes.Repeat([]byte(r.Header.Get("Accept-Language")), 1024))
+	}))
+
+	for _, language := range []string{"en", "fr", "fr", "en"} {
+		r := httptest.NewRequest(http.MethodGet, "/speech", nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		r.Header.Set("Accept-Language", language)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		assert.Equal(t, strings.Repeat(language, 1024), decompressCached(t, w.Body.Bytes()), language)
+	}
+
+	assert.Equal(t, 3, full)
+	assert.Equal(t, CacheStats{Hits: 1, Misses: 3, Entries: 1, Bytes: cache.Stats().Bytes}, cache.Stats())
+}
+
+func TestHTTPWithCache_optional_interfaces(t *testing.T) {
+	tests := []struct {
+		name      string
+		newWriter func() (http.ResponseWriter, *httptest.ResponseRecorder)
+		want      bool
+	}{
+		{
+			name: "hijacker pusher",
+			newWriter: func() (http.ResponseWriter, *httptest.ResponseRecorder) {
+				recorder := httptest.NewRecorder()
+				return &hijackerPusherRecorder{
+					hijackerRecorder: hijackerRecorder{ResponseRecorder: recorder},
+					pusherRecorder:   pusherRecorder{ResponseRecorder: recorder},
+				}, recorder
+			},
+			want: true,
+		},
+		{
+			name: "plain",
+			newWriter: func() (http.ResponseWriter, *httptest.ResponseRecorder) {
+				recorder := httptest.NewRecorder()
+				return recorder, recorder
+			},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := NewCache(1<<20, 0)
+			config := defaultConfig
+			config.Cache = cache
+			instance := &cachedInstance{etag: `"v1"`, payload: bigPayload}
+			handler := NewHandler(config).WrapHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, isHijacker := w.(http.Hijacker)
+				assert.Equal(t, tt.want, isHijacker)
+				pusher, isPusher := w.(http.Pusher)
+				assert.Equal(t, tt.want, isPusher)
+				if isPusher {
+					assert.NoError(t, pusher.Push("/style.css", nil))
+				}
+				instance.ServeHTTP(w, r)
+			}))
+
+			for i := 0; i < 2; i++ {
+				w, recorder := tt.newWriter()
+				r := httptest.NewRequest(http.MethodGet, "/speech", nil)
+				r.Header.Set("Accept-Encoding", "gzip")
+				handler.ServeHTTP(w, r)
+
+				if tt.want {
+					assert.Equal(t, []string{"/style.css"}, w.(*hijackerPusherRecorder).pushed)
+				}
+				assert.Equal(t, string(bigPayload), decompressCached(t, recorder.Body.Bytes()))
+			}
+
+			assert.EqualValues(t, 1, cache.Stats().Hits)
+		})
+	}
+}
+
+func Test_validationWriter_Hijack(t *testing.T) {
+	recorder := &hijackerRecorder{ResponseRecorder: httptest.NewRecorder()}
+	_, _, err := (&validationWriter{ResponseWriter: recorder}).Hijack()
+	assert.NoError(t, err)
+	assert.True(t, recorder.hijacked)
+
+	_, _, err = (&validationWriter{ResponseWriter: httptest.NewRecorder()}).Hijack()
+	assert.Error(t, err)
+	assert.Equal(t, http.ErrNotSupported, (&validationWriter{ResponseWriter: httptest.NewRecorder()}).Push("/", nil))
+}
+
+func TestHTTPWithCache_entry_limit(t *testing.T) {
+	cache := NewCache(1<<20, 0)
+	cache.SetMaxEntryBytes(64)
+	config := defaultConfig
+	config.Cache = cache
+	instance := &cachedInstance{etag: `"v1"`, payload: bigPayload}
+	handler := NewHandler(config).WrapHandler(instance)
+
+	for i := 0; i < 2; i++ {
+		r := httptest.NewRequest(http.MethodGet, "/speech", nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		assert.Equal(t, string(bigPayload), decompressCached(t, w.Body.Bytes()))
+	}
+
+	assert.Equal(t, 2, instance.full)
+	assert.Zero(t, cache.Stats().Entries)
+}
+
+func TestHTTPWithCache_not_cacheable(t *testing.T) {
+	cache := NewCache(1<<20, 0)
+	config := defaultConfig
+	config.Cache = cache
+	handler := NewHandler(config).WrapHandler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write(bigPayload)
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/speech", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	assert.Zero(t, cache.Stats().Entries)
+}
+
+func TestGinWithCache(t *testing.T) {
+	cache := NewCache(1<<20, 0)
+	config := defaultConfig
+	config.Cache = cache
+	instance := &cachedInstance{etag: `"v1"`, payload: bigPayload}
+
+	g := gin.New()
+	g.Use(NewHandler(config).Gin)
+	g.GET("/speech", gin.WrapH(instance))
+
+	for i := 0; i < 2; i++ {
+		r := httptest.NewRequest(http.MethodGet, "/speech", nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		g.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+		assert.Equal(t, string(bigPayload), decompressCached(t, w.Body.Bytes()))
+	}
+
+	assert.Equal(t, 1, instance.full)
+	assert.EqualValues(t, 1, cache.Stats().Hits)
+}
+
+// personalInstance serves per-user JSON with Last-Modified only,
+// answering If-Modified-Since regardless of the user.
+type personalInstance struct {
+	cacheControl string
+}
+
+func (p *personalInstance) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user := r.Header.Get("Authorization")
+	if cookie, err := r.Cookie("session"); err == nil {
+		user = cookie.Value
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Last-Modified", "Fri, 01 Jan 2021 00:00:00 GMT")
+	if p.cacheControl != "" {
+		w.Header().Set("Cache-Control", p.cacheControl)
+	}
+	if r.Header.Get("If-Modified-Since") != "" {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	_, _ = w.Write([]byte(`{"user":"` + user + `","padding":"` + strings.Repeat("x", 2048) + `"}`))
+}
+
+func TestHTTPWithCache_personal(t *testing.T) {
+	tests := []struct {
+		name         string
+		header       string
+		cacheControl string
+		varyKey      func(req *http.Request) string
+		wantShared   bool
+	}{
+		{name: "authorization", header: "Authorization"},
+		{name: "cookie", header: "Cookie"},
+		{
+			name:    "cookie not covered by vary key",
+			header:  "Cookie",
+			varyKey: func(req *http.Request) string { return req.Header.Get("Accept-Language") },
+		},
+		{
+			name:   "cookie covered by vary key",
+			header: "Cookie",
+			varyKey: func(req *http.Request) string {
+				cookie, _ := req.Cookie("session")
+				if cookie == nil {
+					return ""
+				}
+				return cookie.Value
+			},
+			wantShared: true,
+		},
+		{name: "authorization public", header: "Authorization", cacheControl: "public, max-age=60", wantShared: true},
+		{name: "authorization must-revalidate", header: "Authorization", cacheControl: "must-revalidate", wantShared: true},
+		{name: "authorization s-maxage", header: "Authorization", cacheControl: "s-maxage=60", wantShared: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := NewCache(1<<20, 0)
+			config := defaultConfig
+			config.Cache = cache
+			config.CacheVaryKey = tt.varyKey
+			handler := NewHandler(config).WrapHandler(&personalInstance{cacheControl: tt.cacheControl})
+
+			serve := func(user string) string {
+				r := httptest.NewRequest(http.MethodGet, "/me", nil)
+				r.Header.Set("Accept-Encoding", "gzip")
+				if tt.header == "Cookie" {
+					r.Header.Set("Cookie", "session="+user)
+				} else {
+					r.Header.Set("Authorization", user)
+				}
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, r)
+				require.Equal(t, http.StatusOK, w.Code)
+				require.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+				return decompressCached(t, w.Body.Bytes())
+			}
+
+			assert.Contains(t, serve("alice"), `"user":"alice"`)
+			bob := serve("bob")
+			if tt.wantShared && tt.varyKey == nil {
+				// explicitly shared by the response
+				assert.Contains(t, bob, `"user":"alice"`)
+				assert.EqualValues(t, 1, cache.Stats().Hits)
+				return
+			}
+
+			assert.Contains(t, bob, `"user":"bob"`)
+			assert.Zero(t, cache.Stats().Hits)
+			if tt.wantShared {
+				assert.Equal(t, 2, cache.Stats().Entries)
+			} else {
+				assert.Zero(t, cache.Stats().Entries)
+			}
+		})
+	}
+}
+
+func TestHTTPWithCache_anonymous_not_served_to_personal(t *testing.T) {
+	cache := NewCache(1<<20, 0)
+	config := defaultConfig
+	config.Cache = cache
+	handler := NewHandler(config).WrapHandler(&personalInstance{})
+
+	for _, user := range []string{"", "bob"} {
+		r := httptest.NewRequest(http.MethodGet, "/me", nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		if user != "" {
+			r.Header.Set("Authorization", user)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		assert.Contains(t, decompressCached(t, w.Body.Bytes()), `"user":"`+user+`"`)
+	}
+
+	assert.Equal(t, CacheStats{Misses: 2, Entries: 1, Bytes: cache.Stats().Bytes}, cache.Stats())
+}
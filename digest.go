@@ -0,0 +1,135 @@
+package gzip
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"hash"
+	"net/http"
+	"strings"
+)
+
+// DigestPolicy decides how Handler deals with responses carrying
+// integrity fields computed over the uncompressed body,
+// i.e. Content-Digest and Repr-Digest of RFC 9530,
+// and the legacy Digest and Content-MD5,
+// which are wrong for the compressed bytes on the wire.
+type DigestPolicy int
+
+const (
+	// DigestSkip does not compress responses carrying integrity fields.
+	DigestSkip DigestPolicy = iota
+	// DigestRecompute compresses the response, and sends a Content-Digest
+	// computed over the compressed body as a trailer,
+	// using the algorithms of the original Content-Digest, or Repr-Digest,
+	// among "sha-256" and "sha-512", or "sha-256" if there's none of them,
+	// e.g. only Digest or Content-MD5 is present.
+	//
+	// The original integrity fields are removed.
+	DigestRecompute
+	// DigestToRepr is DigestRecompute sending Repr-Digest instead,
+	// which describes the compressed representation,
+	// and stays valid for Range responses served from Cache.
+	DigestToRepr
+)
+
+// isValid tells whether p is a known DigestPolicy
+func (p DigestPolicy) isValid() bool {
+	return p >= DigestSkip && p <= DigestToRepr
+}
+
+// skips tells whether p leaves the response of header uncompressed,
+// so that its integrity fields stay valid.
+func (p DigestPolicy) skips(header http.Header) bool {
+	return p == DigestSkip && hasDigest(header)
+}
+
+// digestFields are integrity fields computed over the uncompressed body
+var digestFields = []string{"Content-Digest", "Repr-Digest", "Digest", "Content-Md5"}
+
+// hasDigest tells whether header carries any of digestFields
+func hasDigest(header http.Header) bool {
+	for _, field := range digestFields {
+		if _, ok := header[field]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// digestHash is a hash of Content-Digest
+type digestHash struct {
+	algorithm string
+	hash.Hash
+}
+
+// newDigestHashes returns hashes of the supported algorithms
+// listed in contentDigest, e.g. "sha-256=:...:, sha-512=:...:".
+func newDigestHashes(contentDigest string) []digestHash {
+	var hashes []digestHash
+
+	for contentDigest != "" {
+		var member string
+		member, contentDigest, _ = cutString(contentDigest, ",")
+		algorithm, _, _ := cutString(member, "=")
+		algorithm = strings.ToLower(strings.TrimSpace(algorithm))
+
+		switch algorithm {
+		case "sha-256":
+			hashes = append(hashes, digestHash{algorithm: algorithm, Hash: sha256.New()})
+		case "sha-512":
+			hashes = append(hashes, digestHash{algorithm: algorithm, Hash: sha512.New()})
+		}
+	}
+
+	return hashes
+}
+
+// digestWriter feeds hashes with what's written into it
+type digestWriter []digestHash
+
+func (d digestWriter) Write(data []byte) (int, error) {
+	for _, h := range d {
+		_, _ = h.Write(data)
+	}
+
+	return len(data), nil
+}
+
+// formatContentDigest formats hashes as value of Content-Digest
+func formatContentDigest(hashes []digestHash) string {
+	var value strings.Builder
+	for i, h := range hashes {
+		if i > 0 {
+			value.WriteString(", ")
+		}
+		value.WriteString(h.algorithm)
+		value.WriteString("=:")
+		value.WriteString(base64.StdEncoding.EncodeToString(h.Sum(nil)))
+		value.WriteString(":")
+	}
+
+	return value.String()
+}
+
+// prepareDigest rewrites integrity fields of the compressed response
+// by DigestPolicy.
+func (w *writerWrapper) prepareDigest(header http.Header) {
+	digest := strings.Join(header["Content-Digest"], ", ")
+	if digest == "" {
+		digest = strings.Join(header["Repr-Digest"], ", ")
+	}
+	for _, field := range digestFields {
+		header.Del(field)
+	}
+
+	w.digestHashes = newDigestHashes(digest)
+	if len(w.digestHashes) == 0 {
+		w.digestHashes = newDigestHashes("sha-256")
+	}
+	w.digestField = "Content-Digest"
+	if w.DigestPolicy == DigestToRepr {
+		w.digestField = "Repr-Digest"
+	}
+}
@@ -0,0 +1,249 @@
+package gzip
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sha256Digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+}
+
+func sha512Digest(data []byte) string {
+	sum := sha512.Sum512(data)
+	return "sha-512=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+}
+
+func Test_newDigestHashes(t *testing.T) {
+	tests := []struct {
+		name          string
+		contentDigest string
+		want          string
+	}{
+		{"empty", "", ""},
+		{"sha-256", sha256Digest(bigPayload), sha256Digest(smallPayload)},
+		{"both", sha512Digest(bigPayload) + ", " + sha256Digest(bigPayload), sha512Digest(smallPayload) + ", " + sha256Digest(smallPayload)},
+		{"unsupported", "md5=:abc:, SHA-256=:def:", sha256Digest(smallPayload)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hashes := newDigestHashes(tt.contentDigest)
+			_, _ = digestWriter(hashes).Write(smallPayload)
+			assert.Equal(t, tt.want, formatContentDigest(hashes))
+		})
+	}
+}
+
+func newDigestInstance(config Config) http.Handler {
+	return NewHandler(config).WrapHandler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Content-Digest", sha256Digest(bigPayload))
+		w.Header().Set("Digest", "SHA-256="+base64.StdEncoding.EncodeToString([]byte("legacy")))
+		_, _ = w.Write(bigPayload)
+	}))
+}
+
+func TestHTTPWithDigestSkip(t *testing.T) {
+	for _, field := range digestFields {
+		t.Run(field, func(t *testing.T) {
+			handler := DefaultHandler().WrapHandler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+				w.Header().Set(field, "whatever")
+				_, _ = w.Write(bigPayload)
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Accept-Encoding", "gzip")
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			assert.Empty(t, w.Header().Get("Content-Encoding"))
+			assert.Equal(t, "whatever", w.Header().Get(field))
+			assert.Equal(t, bigPayload, w.Body.Bytes())
+		})
+	}
+}
+
+func TestHTTPWithDigestRecompute(t *testing.T) {
+	config := defaultConfig
+	config.DigestPolicy = DigestRecompute
+	server := httptest.NewServer(newDigestInstance(config))
+	defer server.Close()
+
+	r, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+	r.Header.Set("Accept-Encoding", "gzip")
+
+	result, err := server.Client().Do(r)
+	require.NoError(t, err)
+	defer result.Body.Close()
+
+	require.Equal(t, "gzip", result.Header.Get("Content-Encoding"))
+	assert.Empty(t, result.Header.Get("Content-Digest"))
+	assert.Empty(t, result.Header.Get("Digest"))
+
+	compressed, err := ioutil.ReadAll(result.Body)
+	require.NoError(t, err)
+	assert.Equal(t, sha256Digest(compressed), result.Trailer.Get("Content-Digest"))
+}
+
+func TestHTTPWithDigestRecompute_without_supported_digest(t *testing.T) {
+	tests := []struct {
+		name  string
+		field string
+		value string
+	}{
+		{"digest only", "Digest", "SHA-256=" + base64.StdEncoding.EncodeToString([]byte("legacy"))},
+		{"content-md5 only", "Content-Md5", base64.StdEncoding.EncodeToString([]byte("legacy"))},
+		{"unsupported algorithm", "Content-Digest", "md5=:" + base64.StdEncoding.EncodeToString([]byte("legacy")) + ":"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := defaultConfig
+			config.DigestPolicy = DigestRecompute
+			server := httptest.NewServer(NewHandler(config).WrapHandler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+				w.Header().Set(tt.field, tt.value)
+				_, _ = w.Write(bigPayload)
+			})))
+			defer server.Close()
+
+			r, err := http.NewRequest(http.MethodGet, server.URL, nil)
+			require.NoError(t, err)
+			r.Header.Set("Accept-Encoding", "gzip")
+
+			result, err := server.Client().Do(r)
+			require.NoError(t, err)
+			defer result.Body.Close()
+
+			require.Equal(t, "gzip", result.Header.Get("Content-Encoding"))
+			assert.Empty(t, result.Header.Get(tt.field))
+
+			compressed, err := ioutil.ReadAll(result.Body)
+			require.NoError(t, err)
+			assert.Equal(t, sha256Digest(compressed), result.Trailer.Get("Content-Digest"))
+		})
+	}
+}
+
+func Test_DigestPolicy_skips(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy DigestPolicy
+		header http.Header
+		want   bool
+	}{
+		{"no digest", DigestSkip, http.Header{}, false},
+		{"skip", DigestSkip, http.Header{"Content-Digest": []string{"sha-256=:abc:"}}, true},
+		{"recompute", DigestRecompute, http.Header{"Digest": []string{"SHA-256=abc"}}, false},
+		{"skip repr", DigestSkip, http.Header{"Repr-Digest": []string{"sha-256=:abc:"}}, true},
+		{"to repr", DigestToRepr, http.Header{"Content-Digest": []string{"sha-256=:abc:"}}, false},
+		{"to repr legacy only", DigestToRepr, http.Header{"Content-Md5": []string{"abc"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.policy.skips(tt.header))
+		})
+	}
+}
+
+func TestHTTPWithDigestToRepr(t *testing.T) {
+	tests := []struct {
+		name   string
+		field  string
+		value  string
+		digest func([]byte) string
+	}{
+		{"content-digest", "Content-Digest", sha256Digest(bigPayload), sha256Digest},
+		{"repr-digest", "Repr-Digest", sha512Digest(bigPayload), sha512Digest},
+		{"content-md5 only", "Content-Md5", base64.StdEncoding.EncodeToString([]byte("legacy")), sha256Digest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := defaultConfig
+			config.DigestPolicy = DigestToRepr
+			server := httptest.NewServer(NewHandler(config).WrapHandler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+				w.Header().Set(tt.field, tt.value)
+				_, _ = w.Write(bigPayload)
+			})))
+			defer server.Close()
+
+			r, err := http.NewRequest(http.MethodGet, server.URL, nil)
+			require.NoError(t, err)
+			r.Header.Set("Accept-Encoding", "gzip")
+
+			result, err := server.Client().Do(r)
+			require.NoError(t, err)
+			defer result.Body.Close()
+
+			require.Equal(t, "gzip", result.Header.Get("Content-Encoding"))
+			assert.Empty(t, result.Header.Get(tt.field))
+			assert.Empty(t, result.Header.Get("Repr-Digest"))
+
+			compressed, err := ioutil.ReadAll(result.Body)
+			require.NoError(t, err)
+			assert.Equal(t, tt.digest(compressed), result.Trailer.Get("Repr-Digest"))
+			assert.Empty(t, result.Trailer.Get("Content-Digest"))
+		})
+	}
+}
+
+func TestHTTPWithDigest_cached_range(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      DigestPolicy
+		field       string
+		keptOnRange bool
+	}{
+		{"recompute", DigestRecompute, "Content-Digest", false},
+		{"to repr", DigestToRepr, "Repr-Digest", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := defaultConfig
+			config.DigestPolicy = tt.policy
+			config.RangePolicy = RangeServeCompressed
+			config.Cache = NewCache(1<<20, 0)
+			handler := NewHandler(config).WrapHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Digest", sha256Digest(bigPayload))
+				http.ServeContent(w, r, "speech.txt", rangeModTime, bytes.NewReader(bigPayload))
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Accept-Encoding", "gzip")
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			require.Equal(t, http.StatusOK, w.Code)
+			compressed := w.Body.Bytes()
+
+			// full response from cache
+			w = httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, sha256Digest(compressed), w.Header().Get(tt.field))
+
+			r.Header.Set("Range", "bytes=0-9")
+			w = httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			assert.Equal(t, http.StatusPartialContent, w.Code)
+			assert.Equal(t, compressed[:10], w.Body.Bytes())
+			if tt.keptOnRange {
+				assert.Equal(t, sha256Digest(compressed), w.Header().Get(tt.field))
+			} else {
+				assert.Empty(t, w.Header().Get(tt.field))
+			}
+			assert.EqualValues(t, 2, config.Cache.Stats().Hits)
+		})
+	}
+}
@@ -1,4 +1,4 @@
-// Package gzip implements gzip middleware for Gin and net/http.
+// Package gzip implements gzip, brotli and zstd middleware for Gin and net/http.
 //
 // * introduction: https://github.com/nanmu42/gzip
 //
@@ -0,0 +1,185 @@
+package gzip
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/bits"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// content-coding tokens, see https://www.iana.org/assignments/http-parameters/http-parameters.xhtml#content-coding
+const (
+	encodingGzip   = "gzip"
+	encodingBrotli = "br"
+	encodingZstd   = "zstd"
+)
+
+// window size bounds of encoders for streaming responses,
+// brotli supports window sizes up to 16MB.
+const (
+	minStreamingWindowSize     = 1 << 10
+	maxStreamingWindowSize     = 1 << 24
+	defaultStreamingWindowSize = 32 * 1024
+)
+
+// defaultBrotliCompressionLevel is used when BrotliCompressionLevel is zero,
+// brotli level 0 does worse than gzip at level 6.
+const defaultBrotliCompressionLevel = 4
+
+// defaultZstdWindowSize is used when ZstdWindowSize is zero,
+// bounding the memory each pooled zstd encoder takes.
+const defaultZstdWindowSize = 256 * 1024
+
+// builtinEncodings are built-in content-codings in the order of preference
+var builtinEncodings = []string{encodingZstd, encodingBrotli, encodingGzip}
+
+// Encoder compresses data written into it in a specific content-coding,
+// *gzip.Writer, *brotli.Writer and *zstd.Encoder are all Encoders.
+//
+// Handler pools Encoders, an Encoder is always Reset before use,
+// and is Closed and Reset to ioutil.Discard before being put back to pool.
+type Encoder interface {
+	io.WriteCloser
+	// Flush writes any pending data to the underlying writer
+	Flush() error
+	// Reset discards the encoder's state and makes it
+	// write to w, just like a freshly initialized one
+	Reset(w io.Writer)
+}
+
+// EncoderFactory creates a new Encoder,
+// it's called when Handler's pool of Encoders runs dry.
+type EncoderFactory func() Encoder
+
+func gzipEncoderFactory(level int) EncoderFactory {
+	return func() Encoder {
+		// level is validated in NewHandler()
+		writer, _ := gzip.NewWriterLevel(ioutil.Discard, level)
+		return writer
+	}
+}
+
+func brotliEncoderFactory(level int) EncoderFactory {
+	return func() Encoder {
+		return brotli.NewWriterLevel(ioutil.Discard, level)
+	}
+}
+
+func brotliStreamingEncoderFactory(level, windowSize int) EncoderFactory {
+	return func() Encoder {
+		return brotli.NewWriterOptions(ioutil.Discard, brotli.WriterOptions{
+			Quality: level,
+			LGWin:   bits.TrailingZeros(uint(windowSize)),
+		})
+	}
+}
+
+func zstdEncoderFactory(options []zstd.EOption) EncoderFactory {
+	return func() Encoder {
+		// options are validated in NewHandler()
+		writer, _ := zstd.NewWriter(nil, options...)
+		return writer
+	}
+}
+
+func newEncoderPools(registry map[string]EncoderFactory) map[string]*sync.Pool {
+	pools := make(map[string]*sync.Pool, len(registry))
+	for encoding, factory := range registry {
+		factory := factory
+		pools[encoding] = &sync.Pool{
+			New: func() interface{} {
+				return factory()
+			},
+		}
+	}
+
+	return pools
+}
+
+// encodingsInPreference lists content-codings in registry in the order of preference,
+// built-in ones go first, then the others in lexical order.
+func encodingsInPreference(registry map[string]EncoderFactory) []string {
+	var (
+		encodings = make([]string, 0, len(registry))
+		custom    = make([]string, 0, len(registry))
+	)
+
+	for _, encoding := range builtinEncodings {
+		if _, ok := registry[encoding]; ok {
+			encodings = append(encodings, encoding)
+		}
+	}
+	for encoding := range registry {
+		if !isBuiltinEncoding(encoding) {
+			custom = append(custom, encoding)
+		}
+	}
+	sort.Strings(custom)
+
+	return append(encodings, custom...)
+}
+
+func isBuiltinEncoding(encoding string) bool {
+	for _, item := range builtinEncodings {
+		if item == encoding {
+			return true
+		}
+	}
+
+	return false
+}
+
+// EncodingOverride overrides the preference of content-codings
+// for responses of specific content types.
+type EncodingOverride struct {
+	// ContentTypes are media types this override applies to,
+	// e.g. "text/css", "application/json".
+	//
+	// They are matched against response's Content-Type without parameters,
+	// case-insensitively.
+	ContentTypes []string
+	// Preference is the order of preference of content-codings
+	// for these content types,
+	// content-codings not listed are not used for these content types.
+	Preference []string
+}
+
+// encodingOverride is the compiled EncodingOverride
+type encodingOverride struct {
+	contentTypes map[string]struct{}
+	encodings    []string
+}
+
+func (o *encodingOverride) matches(mediaType string) bool {
+	_, ok := o.contentTypes[mediaType]
+	return ok
+}
+
+// mediaTypeOf returns the lower-cased media type without parameters
+// in Content-Type
+func mediaTypeOf(contentType string) string {
+	mediaType, _, _ := cutString(contentType, ";")
+	return strings.ToLower(strings.TrimSpace(mediaType))
+}
+
+// normalizeEncodings lower-cases encodings and
+// panics if any of them is not in registry
+func normalizeEncodings(encodings []string, registry map[string]EncoderFactory) []string {
+	normalized := make([]string, 0, len(encodings))
+	for _, encoding := range encodings {
+		encoding = strings.ToLower(encoding)
+		if _, ok := registry[encoding]; !ok {
+			panic(fmt.Sprintf("gzip: unregistered content-coding: %q", encoding))
+		}
+		normalized = append(normalized, encoding)
+	}
+
+	return normalized
+}
@@ -0,0 +1,19 @@
+package gzip
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_encodingsInPreference(t *testing.T) {
+	registry := map[string]EncoderFactory{
+		"x-custom":     nil,
+		encodingGzip:   nil,
+		"deflate":      nil,
+		encodingZstd:   nil,
+		encodingBrotli: nil,
+	}
+
+	assert.Equal(t, []string{"zstd", "br", "gzip", "deflate", "x-custom"}, encodingsInPreference(registry))
+}
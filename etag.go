@@ -0,0 +1,210 @@
+package gzip
+
+import (
+	"net/http"
+	"strings"
+)
+
+// ETagPolicy decides the ETag of compressed representations.
+//
+// A compressed representation differs from the original one byte by byte,
+// so it must not share a strong ETag with the original.
+//
+// see https://www.rfc-editor.org/rfc/rfc9110#section-8.8.3
+type ETagPolicy interface {
+	// Encode returns the ETag of the representation compressed in
+	// content-coding encoding, given etag of the original one.
+	Encode(etag, encoding string) string
+	// Decode maps etag, which comes from If-None-Match or If-Match of request,
+	// back to the ETag of the original representation,
+	// given the content-coding negotiated for the request.
+	//
+	// ok is false if etag is not produced by Encode.
+	Decode(etag, encoding string) (original string, ok bool)
+}
+
+// interface guards
+var (
+	_ ETagPolicy = (*WeakETagPolicy)(nil)
+	_ ETagPolicy = (*SuffixETagPolicy)(nil)
+	_ ETagPolicy = (*KeepETagPolicy)(nil)
+)
+
+// WeakETagPolicy turns strong ETags of compressed representations weak,
+// e.g. "abc" => W/"abc".
+//
+// Weak ETags still work with If-None-Match, whose comparison is weak,
+// but not with If-Match or If-Range.
+type WeakETagPolicy struct{}
+
+// NewWeakETagPolicy ...
+func NewWeakETagPolicy() *WeakETagPolicy {
+	return &WeakETagPolicy{}
+}
+
+// Encode implements ETagPolicy interface
+func (p *WeakETagPolicy) Encode(etag, _ string) string {
+	if strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+
+	return "W/" + etag
+}
+
+// Decode implements ETagPolicy interface
+//
+// Weak comparison matches W/"abc" with "abc" already,
+// so there's nothing to map.
+func (p *WeakETagPolicy) Decode(etag, _ string) (string, bool) {
+	return etag, false
+}
+
+// SuffixETagPolicy suffixes ETags of compressed representations
+// with their content-codings, e.g. "abc" => "abc-gzip".
+//
+// Strong ETags stay strong, so that If-Match and If-Range keep working.
+type SuffixETagPolicy struct{}
+
+// NewSuffixETagPolicy ...
+func NewSuffixETagPolicy() *SuffixETagPolicy {
+	return &SuffixETagPolicy{}
+}
+
+// Encode implements ETagPolicy interface
+func (p *SuffixETagPolicy) Encode(etag, encoding string) string {
+	if len(etag) < 2 || etag[len(etag)-1] != '"' {
+		// malformed, leave it alone
+		return etag
+	}
+
+	return etag[:len(etag)-1] + "-" + encoding + `"`
+}
+
+// Decode implements ETagPolicy interface
+func (p *SuffixETagPolicy) Decode(etag, encoding string) (string, bool) {
+	suffix := "-" + encoding + `"`
+	if !strings.HasSuffix(etag, suffix) {
+		return etag, false
+	}
+
+	return etag[:len(etag)-len(suffix)] + `"`, true
+}
+
+// KeepETagPolicy leaves ETags of compressed representations unchanged.
+//
+// Use it only when the original and compressed representations are
+// interchangeable for your clients and caches.
+type KeepETagPolicy struct{}
+
+// NewKeepETagPolicy ...
+func NewKeepETagPolicy() *KeepETagPolicy {
+	return &KeepETagPolicy{}
+}
+
+// Encode implements ETagPolicy interface
+func (p *KeepETagPolicy) Encode(etag, _ string) string {
+	return etag
+}
+
+// Decode implements ETagPolicy interface
+func (p *KeepETagPolicy) Decode(etag, _ string) (string, bool) {
+	return etag, false
+}
+
+// conditionalHeaders are request headers of entity-tag lists
+// mapped by decodeETags().
+//
+// If-Range is left alone, since a partial response is not compressed
+// and can not continue a compressed one.
+var conditionalHeaders = []string{"If-None-Match", "If-Match"}
+
+// decodeETags maps ETags in conditional headers of req back to the original ones
+// with ETagPolicy, trying the negotiated content-codings.
+//
+// A shallow copy of req is returned if any ETag is mapped,
+// otherwise req is returned as is.
+func (w *writerWrapper) decodeETags(req *http.Request) *http.Request {
+	decoded := req
+
+	for _, key := range conditionalHeaders {
+		value := req.Header.Get(key)
+		if value == "" {
+			continue
+		}
+
+		etags := splitETags(value)
+		mapped := false
+		for i, etag := range etags {
+			if original, encoding, ok := w.decodeETag(etag); ok {
+				etags[i] = original
+				w.etagEncoding = encoding
+				mapped = true
+			}
+		}
+		if !mapped {
+			continue
+		}
+
+		if decoded == req {
+			decoded = new(http.Request)
+			*decoded = *req
+			decoded.Header = req.Header.Clone()
+		}
+		decoded.Header.Set(key, strings.Join(etags, ", "))
+	}
+
+	return decoded
+}
+
+// decodeETag decodes etag with the content-coding negotiated by default
+// or for EncodingOverrides
+func (w *writerWrapper) decodeETag(etag string) (original, encoding string, ok bool) {
+	if w.encoding != "" {
+		if original, ok = w.ETagPolicy.Decode(etag, w.encoding); ok {
+			return original, w.encoding, true
+		}
+	}
+	for _, encoding = range w.overrideEncodings {
+		if encoding == "" {
+			continue
+		}
+		if original, ok = w.ETagPolicy.Decode(etag, encoding); ok {
+			return original, encoding, true
+		}
+	}
+
+	return etag, "", false
+}
+
+// splitETags splits a comma separated list of entity-tags,
+// in which commas may be quoted.
+func splitETags(s string) []string {
+	var etags []string
+
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		if s == "" {
+			return etags
+		}
+
+		end := 0
+		if strings.HasPrefix(s, "W/") {
+			end = 2
+		}
+		if end < len(s) && s[end] == '"' {
+			// quoted, find the closing quote
+			if closing := strings.IndexByte(s[end+1:], '"'); closing >= 0 {
+				end += closing + 2
+			} else {
+				end = len(s)
+			}
+		} else if comma := strings.IndexByte(s, ','); comma >= 0 {
+			end = comma
+		} else {
+			end = len(s)
+		}
+
+		etags = append(etags, strings.TrimSpace(s[:end]))
+		s = s[end:]
+	}
+}
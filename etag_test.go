@@ -0,0 +1,187 @@
+package gzip
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestETagPolicy_Encode(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   ETagPolicy
+		etag     string
+		encoding string
+		want     string
+	}{
+		{"weak strong", NewWeakETagPolicy(), `"abc"`, "gzip", `W/"abc"`},
+		{"weak weak", NewWeakETagPolicy(), `W/"abc"`, "gzip", `W/"abc"`},
+		{"suffix strong", NewSuffixETagPolicy(), `"abc"`, "gzip", `"abc-gzip"`},
+		{"suffix weak", NewSuffixETagPolicy(), `W/"abc"`, "br", `W/"abc-br"`},
+		{"suffix malformed", NewSuffixETagPolicy(), `abc`, "br", `abc`},
+		{"keep", NewKeepETagPolicy(), `"abc"`, "zstd", `"abc"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.policy.Encode(tt.etag, tt.encoding))
+		})
+	}
+}
+
+func TestETagPolicy_Decode(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   ETagPolicy
+		etag     string
+		encoding string
+		want     string
+		wantOK   bool
+	}{
+		{"weak", NewWeakETagPolicy(), `W/"abc"`, "gzip", `W/"abc"`, false},
+		{"suffix", NewSuffixETagPolicy(), `"abc-gzip"`, "gzip", `"abc"`, true},
+		{"suffix weak", NewSuffixETagPolicy(), `W/"abc-br"`, "br", `W/"abc"`, true},
+		{"suffix other coding", NewSuffixETagPolicy(), `"abc-br"`, "gzip", `"abc-br"`, false},
+		{"suffix original", NewSuffixETagPolicy(), `"abc"`, "gzip", `"abc"`, false},
+		{"keep", NewKeepETagPolicy(), `"abc"`, "gzip", `"abc"`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.policy.Decode(tt.etag, tt.encoding)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.wantOK, ok)
+		})
+	}
+}
+
+func Test_splitETags(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want []string
+	}{
+		{"empty", "", nil},
+		{"any", "*", []string{"*"}},
+		{"single", `"abc"`, []string{`"abc"`}},
+		{"list", `"abc", W/"def",  "ghi"`, []string{`"abc"`, `W/"def"`, `"ghi"`}},
+		{"quoted comma", `"a,b",W/"c,d"`, []string{`"a,b"`, `W/"c,d"`}},
+		{"unclosed", `"abc, "def"`, []string{`"abc, "`, `def"`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, splitETags(tt.s))
+		})
+	}
+}
+
+func newETagInstance(config Config) http.Handler {
+	return NewHandler(config).WrapHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"speech"`)
+		http.ServeContent(w, r, "speech.txt", rangeModTime, bytes.NewReader(bigPayload))
+	}))
+}
+
+func TestHTTPWithETagPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy ETagPolicy
+		want   string
+	}{
+		{"default", nil, `W/"speech"`},
+		{"weak", NewWeakETagPolicy(), `W/"speech"`},
+		{"suffix", NewSuffixETagPolicy(), `"speech-gzip"`},
+		{"keep", NewKeepETagPolicy(), `"speech"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := defaultConfig
+			config.ETagPolicy = tt.policy
+			handler := newETagInstance(config)
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Accept-Encoding", "gzip")
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+			assert.Equal(t, tt.want, w.Header().Get("ETag"))
+
+			r = httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Accept-Encoding", "gzip")
+			r.Header.Set("If-None-Match", tt.want)
+			w = httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			assert.Equal(t, http.StatusNotModified, w.Code)
+			assert.Empty(t, w.Body.Bytes())
+		})
+	}
+}
+
+func TestHTTPWithSuffixETagPolicy_conditionals(t *testing.T) {
+	config := defaultConfig
+	config.ETagPolicy = NewSuffixETagPolicy()
+	handler := newETagInstance(config)
+
+	// 304 carries ETag of compressed representation
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	r.Header.Set("If-None-Match", `"other", "speech-gzip"`)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Equal(t, `"speech-gzip"`, w.Header().Get("ETag"))
+
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	r.Header.Set("If-Match", `"speech-gzip"`)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	r.Header.Set("If-Match", `"speech-br"`)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+}
+
+func TestHTTPWithSuffixETagPolicy_RangeServeCompressed(t *testing.T) {
+	config := defaultConfig
+	config.ETagPolicy = NewSuffixETagPolicy()
+	config.RangePolicy = RangeServeCompressed
+	config.Cache = NewCache(1<<20, 0)
+	handler := newETagInstance(config)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	r.Header.Set("Range", "bytes=0-9")
+	r.Header.Set("If-Range", `"speech-gzip"`)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, `"speech-gzip"`, w.Header().Get("ETag"))
+	assert.Len(t, w.Body.Bytes(), 10)
+
+	// stale If-Range gets the full response
+	r.Header.Set("If-Range", `"stale-gzip"`)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+}
@@ -3,6 +3,7 @@ module github.com/nanmu42/gzip
 go 1.13
 
 require (
+	github.com/andybalholm/brotli v1.0.4
 	github.com/gin-gonic/gin v1.7.7
 	github.com/go-playground/validator/v10 v10.9.0 // indirect
 	github.com/golang/protobuf v1.5.2 // indirect
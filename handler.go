@@ -6,10 +6,14 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/andybalholm/brotli"
 	"github.com/gin-gonic/gin"
 	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
 )
 
 // These constants are copied from the gzip package
@@ -27,6 +31,21 @@ const (
 	Stateless = gzip.StatelessCompression
 )
 
+// These constants are copied from the brotli package
+const (
+	BrotliBestSpeed          = brotli.BestSpeed
+	BrotliBestCompression    = brotli.BestCompression
+	BrotliDefaultCompression = brotli.DefaultCompression
+)
+
+// These constants are copied from the zstd package
+const (
+	ZstdSpeedFastest           = int(zstd.SpeedFastest)
+	ZstdSpeedDefault           = int(zstd.SpeedDefault)
+	ZstdSpeedBetterCompression = int(zstd.SpeedBetterCompression)
+	ZstdSpeedBestCompression   = int(zstd.SpeedBestCompression)
+)
+
 // Config is used in Handler initialization
 type Config struct {
 	// gzip compression level to use,
@@ -34,6 +53,135 @@ type Config struct {
 	//
 	// see https://golang.org/pkg/compress/gzip/#NewWriterLevel
 	CompressionLevel int
+	// brotli compression level to use,
+	// valid value: 1 => 11,
+	// zero value means 4.
+	//
+	// brotli is preferred over gzip when the client accepts both.
+	//
+	// see https://pkg.go.dev/github.com/andybalholm/brotli#NewWriterLevel
+	BrotliCompressionLevel int
+	// zstd encoder level to use,
+	// valid value: ZstdSpeedFastest => ZstdSpeedBestCompression,
+	// zero value means ZstdSpeedDefault.
+	//
+	// zstd is preferred over brotli and gzip when the client accepts it.
+	//
+	// see https://pkg.go.dev/github.com/klauspost/compress/zstd#EncoderLevel
+	ZstdCompressionLevel int
+	// zstd encoder window size in byte, which bounds the memory
+	// each zstd encoder takes,
+	// valid value: a power of 2 between 1KB and 512MB,
+	// zero value means 256KB.
+	//
+	// see https://pkg.go.dev/github.com/klauspost/compress/zstd#WithWindowSize
+	ZstdWindowSize int
+	// Encoders registers content-codings by their tokens in Accept-Encoding,
+	// e.g. "deflate".
+	//
+	// Built-in "gzip", "br" and "zstd" can be replaced here,
+	// and they are preferred over other content-codings,
+	// which are preferred in lexical order of their tokens.
+	Encoders map[string]EncoderFactory
+	// EncodingPreference is the server's order of preference of content-codings,
+	// e.g. []string{"zstd", "br", "gzip"},
+	// which breaks ties between content-codings weighted equally by
+	// client's Accept-Encoding.
+	//
+	// Content-codings not listed are not used.
+	// Zero value means built-in content-codings in order "zstd", "br", "gzip",
+	// followed by others registered in Encoders.
+	EncodingPreference []string
+	// EncodingOverrides overrides EncodingPreference for responses
+	// of specific content types, the first match wins.
+	//
+	// e.g. prefer br for static text while gzip for streamed JSON.
+	EncodingOverrides []EncodingOverride
+	// StreamingContentTypes are media types of streaming responses,
+	// e.g. "text/event-stream".
+	//
+	// Streaming responses are compressed without waiting for MinContentLength,
+	// and the encoder is sync flushed on every Flush(),
+	// as well as at every event boundary of "text/event-stream".
+	//
+	// To bound the memory every connection holds,
+	// built-in gzip encodes streaming responses Stateless,
+	// while br and zstd encode them with a window of StreamingWindowSize.
+	StreamingContentTypes []string
+	// StreamingWindowSize is the window size in byte of
+	// built-in br and zstd encoders for streaming responses,
+	// valid value: a power of 2 between 1KB and 16MB,
+	// zero value means 32KB.
+	StreamingWindowSize int
+	// AutoFlushSize sync flushes compressed response after every
+	// AutoFlushSize bytes of uncompressed data written,
+	// so that slowly produced response is not held by the encoder.
+	//
+	// Zero value disables it.
+	AutoFlushSize int
+	// AutoFlushLatency sync flushes compressed response
+	// when written data has been pending in the encoder
+	// for at most AutoFlushLatency.
+	//
+	// A background timer bound to request's context is run
+	// for every compressed response, so it should not be too short.
+	//
+	// Zero value disables it.
+	AutoFlushLatency time.Duration
+	// SizeTrailer, if not empty, is the name of a trailer
+	// sent along with compressed responses,
+	// carrying the size in byte of the uncompressed response body,
+	// e.g. "X-Uncompressed-Size".
+	//
+	// Trailers are only delivered with chunked encoding or HTTP/2,
+	// which is what compressed responses go with.
+	SizeTrailer string
+	// ChecksumTrailer, if not empty, is the name of a trailer
+	// sent along with compressed responses,
+	// carrying the CRC-32 (IEEE) checksum of the uncompressed response body
+	// in hex, e.g. "X-Uncompressed-Crc32".
+	ChecksumTrailer string
+	// RangePolicy decides how Range requests are dealt with,
+	// zero value means RangePassThrough.
+	RangePolicy RangePolicy
+	// ETagPolicy decides ETags of compressed responses,
+	// and maps ETags in If-None-Match and If-Match back
+	// to the original ones before the request reaches the handler.
+	//
+	// nil value means NewWeakETagPolicy(), which prefixes "W/".
+	ETagPolicy ETagPolicy
+	// DigestPolicy decides how responses carrying Content-Digest,
+	// Digest or Content-MD5 computed over the uncompressed body are dealt with,
+	// zero value means DigestSkip, which leaves them uncompressed.
+	DigestPolicy DigestPolicy
+	// Cache, if not nil, caches compressed responses in memory,
+	// keyed by method, host, path and query of request,
+	// CacheVaryKey and the negotiated content-coding.
+	//
+	// see Cache for what is cached and how.
+	Cache *Cache
+	// CacheVaryKey returns an extra part of cache key for req,
+	// so that variants of responses varying on request headers
+	// other than Accept-Encoding, e.g. Accept-Language,
+	// are cached side by side instead of replacing each other.
+	//
+	// Requests with Cookie are cached as personal ones, see Cache,
+	// unless CacheVaryKey covers Cookie, i.e. it returns
+	// a different key once Cookie is removed from the request.
+	//
+	// nil value means no extra part.
+	CacheVaryKey func(req *http.Request) string
+	// CompressStatusCodes are ranges of status codes
+	// whose responses may be compressed,
+	// empty value means all.
+	CompressStatusCodes []StatusCodeRange
+	// SkipStatusCodes are ranges of status codes
+	// whose responses are never compressed,
+	// taking precedence over CompressStatusCodes.
+	//
+	// nil value means DefaultSkipStatusCodes(), i.e. 204 and 304.
+	// 206 is never compressed regardless.
+	SkipStatusCodes []StatusCodeRange
 	// Minimum content length to trigger gzip,
 	// the unit is in byte.
 	//
@@ -48,16 +196,32 @@ type Config struct {
 	RequestFilter []RequestFilter
 	// Filters are applied in the sequence here
 	ResponseHeaderFilter []ResponseHeaderFilter
+	// Filters are applied in the sequence here,
+	// after ResponseHeaderFilter.
+	//
+	// Unlike ResponseHeaderFilter, they see the request
+	// and status code of response as well.
+	ResponseFilter []ResponseFilter
 }
 
 // Handler implement gzip compression for gin and net/http
 type Handler struct {
-	compressionLevel     int
-	minContentLength     int64
-	requestFilter        []RequestFilter
-	responseHeaderFilter []ResponseHeaderFilter
-	gzipWriterPool       sync.Pool
-	wrapperPool          sync.Pool
+	minContentLength int64
+	requestFilter    []RequestFilter
+	// ResponseHeaderFilter adapted, followed by ResponseFilter
+	responseFilter []ResponseFilter
+	// content-codings in the order of preference
+	encodings         []string
+	encodingOverrides []encodingOverride
+	// content-coding => pool of Encoders
+	encoderPools map[string]*sync.Pool
+	// content-coding => pool of Encoders for streaming responses,
+	// encoderPools is used for content-codings absent here
+	streamingEncoderPools map[string]*sync.Pool
+	rangePolicy           RangePolicy
+	cache                 *Cache
+	cacheVaryKey          func(req *http.Request) string
+	wrapperPool           sync.Pool
 }
 
 // NewHandler initialized a costumed gzip handler to take care of response compression.
@@ -67,37 +231,186 @@ func NewHandler(config Config) *Handler {
 	if config.CompressionLevel < Stateless || config.CompressionLevel > BestCompression {
 		panic(fmt.Sprintf("gzip: invalid CompressionLevel: %d", config.CompressionLevel))
 	}
+	brotliLevel := config.BrotliCompressionLevel
+	if brotliLevel == 0 {
+		brotliLevel = defaultBrotliCompressionLevel
+	}
+	if brotliLevel < BrotliBestSpeed || brotliLevel > BrotliBestCompression {
+		panic(fmt.Sprintf("gzip: invalid BrotliCompressionLevel: %d", config.BrotliCompressionLevel))
+	}
 	if config.MinContentLength <= 0 {
 		panic(fmt.Sprintf("gzip: invalid MinContentLength: %d", config.MinContentLength))
 	}
+	if config.AutoFlushSize < 0 {
+		panic(fmt.Sprintf("gzip: invalid AutoFlushSize: %d", config.AutoFlushSize))
+	}
+	if config.AutoFlushLatency < 0 {
+		panic(fmt.Sprintf("gzip: invalid AutoFlushLatency: %s", config.AutoFlushLatency))
+	}
+	if !config.RangePolicy.isValid() {
+		panic(fmt.Sprintf("gzip: invalid RangePolicy: %d", config.RangePolicy))
+	}
+	if !config.DigestPolicy.isValid() {
+		panic(fmt.Sprintf("gzip: invalid DigestPolicy: %d", config.DigestPolicy))
+	}
+	if config.SizeTrailer != "" && !isToken(config.SizeTrailer) {
+		panic(fmt.Sprintf("gzip: invalid SizeTrailer: %q", config.SizeTrailer))
+	}
+	if config.ChecksumTrailer != "" && !isToken(config.ChecksumTrailer) {
+		panic(fmt.Sprintf("gzip: invalid ChecksumTrailer: %q", config.ChecksumTrailer))
+	}
+
+	statusCodes := newStatusCodePolicy(config.CompressStatusCodes, config.SkipStatusCodes)
+	etagPolicy := config.ETagPolicy
+	if etagPolicy == nil {
+		etagPolicy = NewWeakETagPolicy()
+	}
+
+	zstdOptions := []zstd.EOption{
+		// encode in the calling goroutine
+		zstd.WithEncoderConcurrency(1),
+	}
+	if config.ZstdCompressionLevel != 0 {
+		if config.ZstdCompressionLevel < ZstdSpeedFastest || config.ZstdCompressionLevel > ZstdSpeedBestCompression {
+			panic(fmt.Sprintf("gzip: invalid ZstdCompressionLevel: %d", config.ZstdCompressionLevel))
+		}
+		zstdOptions = append(zstdOptions, zstd.WithEncoderLevel(zstd.EncoderLevel(config.ZstdCompressionLevel)))
+	}
+	zstdWindowSize := config.ZstdWindowSize
+	if zstdWindowSize == 0 {
+		zstdWindowSize = defaultZstdWindowSize
+	}
+	// probe the window size alone, the level is validated above
+	if _, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1), zstd.WithWindowSize(zstdWindowSize)); err != nil {
+		panic(fmt.Sprintf("gzip: invalid ZstdWindowSize: %d, %s", config.ZstdWindowSize, err))
+	}
+	zstdOptions = append(zstdOptions, zstd.WithWindowSize(zstdWindowSize))
+
+	streamingWindowSize := config.StreamingWindowSize
+	if streamingWindowSize == 0 {
+		streamingWindowSize = defaultStreamingWindowSize
+	}
+	if streamingWindowSize < minStreamingWindowSize || streamingWindowSize > maxStreamingWindowSize ||
+		streamingWindowSize&(streamingWindowSize-1) != 0 {
+		panic(fmt.Sprintf("gzip: invalid StreamingWindowSize: %d", config.StreamingWindowSize))
+	}
+
+	registry := map[string]EncoderFactory{
+		encodingGzip:   gzipEncoderFactory(config.CompressionLevel),
+		encodingBrotli: brotliEncoderFactory(brotliLevel),
+		encodingZstd:   zstdEncoderFactory(zstdOptions),
+	}
+	streamingZstdOptions := append([]zstd.EOption{}, zstdOptions...)
+	streamingZstdOptions = append(streamingZstdOptions, zstd.WithWindowSize(streamingWindowSize), zstd.WithLowerEncoderMem(true))
+	streamingRegistry := map[string]EncoderFactory{
+		encodingGzip:   gzipEncoderFactory(Stateless),
+		encodingBrotli: brotliStreamingEncoderFactory(brotliLevel, streamingWindowSize),
+		encodingZstd:   zstdEncoderFactory(streamingZstdOptions),
+	}
+	for encoding, factory := range config.Encoders {
+		if encoding == "" || factory == nil {
+			panic(fmt.Sprintf("gzip: invalid Encoders entry: %q", encoding))
+		}
+		encoding = strings.ToLower(encoding)
+		registry[encoding] = factory
+		// replaced built-in content-coding streams with the replacement
+		delete(streamingRegistry, encoding)
+	}
 
 	handler := Handler{
-		compressionLevel:     config.CompressionLevel,
-		minContentLength:     config.MinContentLength,
-		requestFilter:        config.RequestFilter,
-		responseHeaderFilter: config.ResponseHeaderFilter,
+		minContentLength:      config.MinContentLength,
+		requestFilter:         config.RequestFilter,
+		responseFilter:        responseFilters(config.ResponseHeaderFilter, config.ResponseFilter),
+		encodings:             encodingsInPreference(registry),
+		encoderPools:          newEncoderPools(registry),
+		streamingEncoderPools: newEncoderPools(streamingRegistry),
+		rangePolicy:           config.RangePolicy,
+		cache:                 config.Cache,
+		cacheVaryKey:          config.CacheVaryKey,
+	}
+
+	if len(config.EncodingPreference) > 0 {
+		handler.encodings = normalizeEncodings(config.EncodingPreference, registry)
+	}
+	for _, override := range config.EncodingOverrides {
+		compiled := encodingOverride{
+			contentTypes: make(map[string]struct{}, len(override.ContentTypes)),
+			encodings:    normalizeEncodings(override.Preference, registry),
+		}
+		for _, contentType := range override.ContentTypes {
+			compiled.contentTypes[mediaTypeOf(contentType)] = struct{}{}
+		}
+		handler.encodingOverrides = append(handler.encodingOverrides, compiled)
 	}
 
-	handler.gzipWriterPool.New = func() interface{} {
-		writer, _ := gzip.NewWriterLevel(ioutil.Discard, handler.compressionLevel)
-		return writer
+	streamingContentTypes := make(map[string]struct{}, len(config.StreamingContentTypes))
+	for _, contentType := range config.StreamingContentTypes {
+		streamingContentTypes[mediaTypeOf(contentType)] = struct{}{}
 	}
+
 	handler.wrapperPool.New = func() interface{} {
-		return newWriterWrapper(handler.responseHeaderFilter, handler.minContentLength, nil, handler.getGzipWriter, handler.putGzipWriter)
+		wrapper := newWriterWrapper(handler.responseFilter, handler.minContentLength, nil, handler.getEncoder, handler.putEncoder)
+		wrapper.StatusCodes = statusCodes
+		wrapper.EncodingOverrides = handler.encodingOverrides
+		wrapper.StreamingContentTypes = streamingContentTypes
+		wrapper.AutoFlushSize = config.AutoFlushSize
+		wrapper.AutoFlushLatency = config.AutoFlushLatency
+		wrapper.SizeTrailer = http.CanonicalHeaderKey(config.SizeTrailer)
+		wrapper.ChecksumTrailer = http.CanonicalHeaderKey(config.ChecksumTrailer)
+		wrapper.StripAcceptRanges = config.RangePolicy == RangeStripAcceptRanges
+		wrapper.ETagPolicy = etagPolicy
+		wrapper.DigestPolicy = config.DigestPolicy
+		return wrapper
 	}
 
 	return &handler
 }
 
+// responseFilters adapts headerFilters into ResponseFilter,
+// followed by filters.
+func responseFilters(headerFilters []ResponseHeaderFilter, filters []ResponseFilter) []ResponseFilter {
+	adapted := make([]ResponseFilter, 0, len(headerFilters)+len(filters))
+	for _, filter := range headerFilters {
+		adapted = append(adapted, AdaptResponseHeaderFilter(filter))
+	}
+
+	return append(adapted, filters...)
+}
+
+// isToken tells whether s is a token of RFC 9110,
+// which is what header field names are.
+//
+// see https://www.rfc-editor.org/rfc/rfc9110#section-5.6.2
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' {
+			continue
+		}
+		if !strings.ContainsRune("!#$%&'*+-.^_`|~", rune(c)) {
+			return false
+		}
+	}
+	return true
+}
+
 var defaultConfig = Config{
-	CompressionLevel: 6,
-	MinContentLength: 1 * 1024,
+	CompressionLevel:       6,
+	BrotliCompressionLevel: 4,
+	ZstdCompressionLevel:   ZstdSpeedDefault,
+	ZstdWindowSize:         defaultZstdWindowSize,
+	MinContentLength:       1 * 1024,
+	StreamingContentTypes:  []string{"text/event-stream"},
 	RequestFilter: []RequestFilter{
 		NewCommonRequestFilter(),
 		DefaultExtensionFilter(),
 	},
 	ResponseHeaderFilter: []ResponseHeaderFilter{
 		NewSkipCompressedFilter(),
+		NewNoTransformFilter(),
 		DefaultContentTypeFilter(),
 	},
 }
@@ -108,18 +421,55 @@ func DefaultHandler() *Handler {
 	return NewHandler(defaultConfig)
 }
 
-func (h *Handler) getGzipWriter() *gzip.Writer {
-	return h.gzipWriterPool.Get().(*gzip.Writer)
+func (h *Handler) encoderPool(encoding string, streaming bool) *sync.Pool {
+	if streaming {
+		if pool, ok := h.streamingEncoderPools[encoding]; ok {
+			return pool
+		}
+	}
+
+	return h.encoderPools[encoding]
 }
 
-func (h *Handler) putGzipWriter(w *gzip.Writer) {
+func (h *Handler) getEncoder(encoding string, streaming bool) Encoder {
+	return h.encoderPool(encoding, streaming).Get().(Encoder)
+}
+
+func (h *Handler) putEncoder(encoding string, streaming bool, w Encoder) {
 	if w == nil {
 		return
 	}
 
 	_ = w.Close()
 	w.Reset(ioutil.Discard)
-	h.gzipWriterPool.Put(w)
+	h.encoderPool(encoding, streaming).Put(w)
+}
+
+// negotiate decides the content-coding of response judging by request,
+// along with content-codings for h.encodingOverrides in sequence.
+//
+// Empty string means no compression,
+// ok is false if response should not be compressed at all.
+func (h *Handler) negotiate(req *http.Request) (encoding string, overrideEncodings []string, ok bool) {
+	for _, filter := range h.requestFilter {
+		if !filter.ShouldCompress(req) {
+			return
+		}
+	}
+
+	accepted := ParseAcceptEncoding(req.Header)
+	encoding = accepted.Negotiate(h.encodings)
+	ok = encoding != ""
+
+	if len(h.encodingOverrides) > 0 {
+		overrideEncodings = make([]string, len(h.encodingOverrides))
+		for i, override := range h.encodingOverrides {
+			overrideEncodings[i] = accepted.Negotiate(override.encodings)
+			ok = ok || overrideEncodings[i] != ""
+		}
+	}
+
+	return
 }
 
 func (h *Handler) getWriteWrapper() *writerWrapper {
@@ -149,7 +499,7 @@ func (g *ginGzipWriter) WriteHeaderNow() {
 }
 
 func (g *ginGzipWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
-	return g.originWriter.Hijack()
+	return g.wrapper.hijack()
 }
 
 func (g *ginGzipWriter) CloseNotify() <-chan bool {
@@ -169,13 +519,31 @@ func (g *ginGzipWriter) Written() bool {
 }
 
 func (g *ginGzipWriter) Pusher() http.Pusher {
-	// TODO: not sure how to implement gzip for HTTP2
-	return nil
+	pusher := g.originWriter.Pusher()
+	if pusher == nil {
+		return nil
+	}
+
+	return ginPusher{
+		wrapper: g.wrapper,
+		pusher:  pusher,
+	}
+}
+
+// ginPusher passes Accept-Encoding on to pushed requests
+type ginPusher struct {
+	wrapper *writerWrapper
+	pusher  http.Pusher
+}
+
+// Push implements http.Pusher
+func (p ginPusher) Push(target string, opts *http.PushOptions) error {
+	return p.wrapper.pushVia(p.pusher, target, opts)
 }
 
 // WriteString implements interface gin.ResponseWriter
 func (g *ginGzipWriter) WriteString(s string) (int, error) {
-	return g.wrapper.Write([]byte(s))
+	return g.wrapper.WriteString(s)
 }
 
 // Write implements interface gin.ResponseWriter
@@ -198,28 +566,40 @@ func (g *ginGzipWriter) Flush() {
 	g.wrapper.Flush()
 }
 
-// Gin implement gin's middleware
-func (h *Handler) Gin(c *gin.Context) {
-	var shouldCompress = true
+// FlushError is Flush() which reports error,
+// it's preferred by http.ResponseController over Flush().
+func (g *ginGzipWriter) FlushError() error {
+	return g.wrapper.FlushError()
+}
 
-	for _, filter := range h.requestFilter {
-		shouldCompress = filter.ShouldCompress(c.Request)
-		if !shouldCompress {
-			break
-		}
-	}
+// Unwrap returns the original writer for http.ResponseController
+func (g *ginGzipWriter) Unwrap() http.ResponseWriter {
+	return g.originWriter
+}
 
-	if shouldCompress {
+// Gin implement gin's middleware
+func (h *Handler) Gin(c *gin.Context) {
+	if encoding, overrideEncodings, ok := h.negotiate(c.Request); ok {
 		wrapper := h.getWriteWrapper()
-		wrapper.Reset(c.Writer)
+		wrapper.Reset(c.Writer, c.Request, encoding, overrideEncodings...)
 		originWriter := c.Writer
+		originRequest := c.Request
 		c.Writer = &ginGzipWriter{
 			originWriter: c.Writer,
 			wrapper:      wrapper,
 		}
+		hit := h.prepareCache(wrapper, c.Request, encoding, overrideEncodings)
+		if hit != nil {
+			c.Request = hit.validationReq
+		}
+		c.Request = wrapper.decodeETags(c.Request)
 		defer func() {
 			h.putWriteWrapper(wrapper)
+			if hit != nil {
+				hit.serve()
+			}
 			c.Writer = originWriter
+			c.Request = originRequest
 		}()
 	}
 
@@ -229,22 +609,22 @@ func (h *Handler) Gin(c *gin.Context) {
 // WrapHandler wraps a http.Handler, returning its gzip-enabled version
 func (h *Handler) WrapHandler(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var shouldCompress = true
-
-		for _, filter := range h.requestFilter {
-			shouldCompress = filter.ShouldCompress(r)
-			if !shouldCompress {
-				break
-			}
-		}
-
-		if shouldCompress {
+		if encoding, overrideEncodings, ok := h.negotiate(r); ok {
 			wrapper := h.getWriteWrapper()
-			wrapper.Reset(w)
+			wrapper.Reset(w, r, encoding, overrideEncodings...)
 			originWriter := w
-			w = wrapper
+			// decided by the original writer, before prepareCache() replaces it
+			w = wrapper.withOptionalInterfaces()
+			hit := h.prepareCache(wrapper, r, encoding, overrideEncodings)
+			if hit != nil {
+				r = hit.validationReq
+			}
+			r = wrapper.decodeETags(r)
 			defer func() {
 				h.putWriteWrapper(wrapper)
+				if hit != nil {
+					hit.serve()
+				}
 				w = originWriter
 			}()
 		}
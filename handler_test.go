@@ -2,16 +2,24 @@ package gzip
 
 import (
 	"bytes"
+	"compress/flate"
 	"compress/gzip"
+	"fmt"
+	"hash/crc32"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/andybalholm/brotli"
 	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/zstd"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -125,6 +133,138 @@ func TestNewHandler_Checks(t *testing.T) {
 		})
 	})
 
+	assert.Panics(t, func() {
+		NewHandler(Config{
+			CompressionLevel:       5,
+			BrotliCompressionLevel: 12,
+			MinContentLength:       100,
+		})
+	})
+
+	assert.Panics(t, func() {
+		NewHandler(Config{
+			CompressionLevel:     5,
+			ZstdCompressionLevel: 5,
+			MinContentLength:     100,
+		})
+	})
+
+	assert.Panics(t, func() {
+		NewHandler(Config{
+			CompressionLevel: 5,
+			ZstdWindowSize:   1000,
+			MinContentLength: 100,
+		})
+	})
+
+	assert.Panics(t, func() {
+		NewHandler(Config{
+			CompressionLevel: 5,
+			MinContentLength: 100,
+			Encoders: map[string]EncoderFactory{
+				"deflate": nil,
+			},
+		})
+	})
+
+	assert.Panics(t, func() {
+		NewHandler(Config{
+			CompressionLevel:   5,
+			MinContentLength:   100,
+			EncodingPreference: []string{"gzip", "deflate"},
+		})
+	})
+
+	assert.Panics(t, func() {
+		NewHandler(Config{
+			CompressionLevel: 5,
+			MinContentLength: 100,
+			EncodingOverrides: []EncodingOverride{
+				{ContentTypes: []string{"application/json"}, Preference: []string{"deflate"}},
+			},
+		})
+	})
+
+	assert.Panics(t, func() {
+		NewHandler(Config{
+			CompressionLevel:    5,
+			MinContentLength:    100,
+			StreamingWindowSize: 1000,
+		})
+	})
+
+	assert.Panics(t, func() {
+		NewHandler(Config{
+			CompressionLevel:    5,
+			MinContentLength:    100,
+			StreamingWindowSize: 1 << 25,
+		})
+	})
+
+	assert.Panics(t, func() {
+		NewHandler(Config{
+			CompressionLevel: 5,
+			MinContentLength: 100,
+			AutoFlushSize:    -1,
+		})
+	})
+
+	assert.Panics(t, func() {
+		NewHandler(Config{
+			CompressionLevel: 5,
+			MinContentLength: 100,
+			AutoFlushLatency: -time.Second,
+		})
+	})
+
+	assert.Panics(t, func() {
+		NewHandler(Config{
+			CompressionLevel: 5,
+			MinContentLength: 100,
+			SizeTrailer:      "X-Uncompressed Size",
+		})
+	})
+
+	assert.Panics(t, func() {
+		NewHandler(Config{
+			CompressionLevel: 5,
+			MinContentLength: 100,
+			RangePolicy:      RangeServeCompressed + 1,
+		})
+	})
+
+	assert.Panics(t, func() {
+		NewHandler(Config{
+			CompressionLevel: 5,
+			MinContentLength: 100,
+			DigestPolicy:     DigestToRepr + 1,
+		})
+	})
+
+	assert.Panics(t, func() {
+		NewHandler(Config{
+			CompressionLevel: 5,
+			MinContentLength: 100,
+			ChecksumTrailer:  "X-Checksum:",
+		})
+	})
+
+	assert.Panics(t, func() {
+		NewHandler(Config{
+			CompressionLevel: 5,
+			MinContentLength: 100,
+			SkipStatusCodes:  []StatusCodeRange{{599, 500}},
+		})
+	})
+
+	assert.Panics(t, func() {
+		NewHandler(Config{
+			CompressionLevel:    5,
+			MinContentLength:    100,
+			CompressStatusCodes: []StatusCodeRange{{0, 299}},
+		})
+	})
+
 	assert.Panics(t, func() {
 		NewHandler(Config{
 			CompressionLevel: 5,
@@ -318,6 +458,35 @@ func TestGinWithLevelsHandler(t *testing.T) {
 	}
 }
 
+func TestGinWithDefaultHandler_Pusher(t *testing.T) {
+	var pusherNil bool
+	g := gin.New()
+	g.Use(DefaultHandler().Gin)
+	g.GET("/", func(c *gin.Context) {
+		pusher := c.Writer.Pusher()
+		if pusherNil = pusher == nil; pusherNil {
+			return
+		}
+		_ = pusher.Push("/style.css", nil)
+		c.String(http.StatusOK, "pushed")
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+
+	w := httptest.NewRecorder()
+	g.ServeHTTP(w, r)
+	assert.True(t, pusherNil)
+
+	recorder := &pusherRecorder{ResponseRecorder: httptest.NewRecorder()}
+	g.ServeHTTP(recorder, r)
+	assert.False(t, pusherNil)
+	assert.Equal(t, []string{"/style.css"}, recorder.pushed)
+	require.Len(t, recorder.pushOptions, 1)
+	assert.Equal(t, "gzip", recorder.pushOptions[0].Header.Get("Accept-Encoding"))
+	assert.Equal(t, "pushed", recorder.Body.String())
+}
+
 func TestGinWithDefaultHandler_404(t *testing.T) {
 	var (
 		g = newGinInstance(bigPayload, DefaultHandler().Gin)
@@ -417,6 +586,673 @@ func TestHTTPWithDefaultHandler(t *testing.T) {
 	}
 }
 
+func TestHTTPWithDefaultHandler_Brotli(t *testing.T) {
+	var (
+		g = newEchoHTTPInstance(bigPayload, DefaultHandler().WrapHandler)
+		w = httptest.NewRecorder()
+		r = httptest.NewRequest(http.MethodPost, "/", strings.NewReader("brotli"))
+	)
+
+	r.Header.Set("Accept-Encoding", "gzip, deflate, br")
+	g.ServeHTTP(w, r)
+
+	result := w.Result()
+	require.EqualValues(t, http.StatusOK, result.StatusCode)
+	require.Equal(t, "br", result.Header.Get("Content-Encoding"))
+	require.Equal(t, "Accept-Encoding", result.Header.Get("Vary"))
+
+	body, err := ioutil.ReadAll(brotli.NewReader(result.Body))
+	require.NoError(t, err)
+	require.True(t, bytes.HasPrefix(body, []byte("brotli")))
+}
+
+func TestHTTPWithDefaultHandler_Zstd(t *testing.T) {
+	var (
+		g = newEchoHTTPInstance(bigPayload, DefaultHandler().WrapHandler)
+		w = httptest.NewRecorder()
+		r = httptest.NewRequest(http.MethodPost, "/", strings.NewReader("zstd"))
+	)
+
+	r.Header.Set("Accept-Encoding", "gzip, deflate, br, zstd")
+	g.ServeHTTP(w, r)
+
+	result := w.Result()
+	require.EqualValues(t, http.StatusOK, result.StatusCode)
+	require.Equal(t, "zstd", result.Header.Get("Content-Encoding"))
+	require.Equal(t, "Accept-Encoding", result.Header.Get("Vary"))
+
+	reader, err := zstd.NewReader(result.Body)
+	require.NoError(t, err)
+	defer reader.Close()
+	body, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	require.True(t, bytes.HasPrefix(body, []byte("zstd")))
+}
+
+func TestHTTPWithDefaultHandler_AcceptEncodingQValues(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		want           string
+	}{
+		{"gzip;q=0", ""},
+		{"gzip;q=0, *", "zstd"},
+		{"br;q=0.5, gzip", "gzip"},
+		{"x-gzip", "gzip"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.acceptEncoding, func(t *testing.T) {
+			var (
+				g = newHTTPInstance(bigPayload, DefaultHandler().WrapHandler)
+				w = httptest.NewRecorder()
+				r = httptest.NewRequest(http.MethodPost, "/", nil)
+			)
+
+			r.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			g.ServeHTTP(w, r)
+
+			result := w.Result()
+			assert.EqualValues(t, http.StatusOK, result.StatusCode)
+			assert.Equal(t, tt.want, result.Header.Get("Content-Encoding"))
+		})
+	}
+}
+
+func TestHTTPWithEncodingPreference(t *testing.T) {
+	handler := NewHandler(Config{
+		CompressionLevel:   DefaultCompression,
+		MinContentLength:   100,
+		EncodingPreference: []string{"GZIP", "br"},
+		EncodingOverrides: []EncodingOverride{
+			{
+				ContentTypes: []string{"text/css", "text/html"},
+				Preference:   []string{"br"},
+			},
+			{
+				ContentTypes: []string{"image/svg+xml"},
+				Preference:   nil,
+			},
+		},
+	})
+
+	tests := []struct {
+		name           string
+		contentType    string
+		acceptEncoding string
+		want           string
+	}{
+		{"tie broken by server", "text/plain", "br, gzip, zstd", "gzip"},
+		{"client preference wins", "text/plain", "br, gzip;q=0.5", "br"},
+		{"unlisted not used", "text/plain", "zstd", ""},
+		{"override", "text/html; charset=utf-8", "gzip, br", "br"},
+		{"override not acceptable", "text/css", "gzip", ""},
+		{"override to no compression", "image/svg+xml", "gzip, br", ""},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				g = handler.WrapHandler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+					w.Header().Set("Content-Type", tt.contentType)
+					_, _ = w.Write(bigPayload)
+				}))
+				w = httptest.NewRecorder()
+				r = httptest.NewRequest(http.MethodGet, "/", nil)
+			)
+
+			r.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			g.ServeHTTP(w, r)
+
+			result := w.Result()
+			assert.EqualValues(t, http.StatusOK, result.StatusCode)
+			assert.Equal(t, tt.want, result.Header.Get("Content-Encoding"))
+			if tt.want == "" {
+				assert.Equal(t, bigPayload, w.Body.Bytes())
+			}
+		})
+	}
+}
+
+func TestHTTPWithCustomEncoder(t *testing.T) {
+	var (
+		g = newEchoHTTPInstance(bigPayload, NewHandler(Config{
+			CompressionLevel: DefaultCompression,
+			MinContentLength: 100,
+			Encoders: map[string]EncoderFactory{
+				"deflate": func() Encoder {
+					writer, _ := flate.NewWriter(ioutil.Discard, flate.DefaultCompression)
+					return writer
+				},
+			},
+		}).WrapHandler)
+	)
+
+	for _, acceptEncoding := range []string{"deflate", "deflate, gzip"} {
+		acceptEncoding := acceptEncoding
+		t.Run(acceptEncoding, func(t *testing.T) {
+			var (
+				w = httptest.NewRecorder()
+				r = httptest.NewRequest(http.MethodPost, "/", strings.NewReader("deflate"))
+			)
+
+			r.Header.Set("Accept-Encoding", acceptEncoding)
+			g.ServeHTTP(w, r)
+
+			result := w.Result()
+			require.EqualValues(t, http.StatusOK, result.StatusCode)
+
+			var reader io.Reader
+			if strings.Contains(acceptEncoding, "gzip") {
+				require.Equal(t, "gzip", result.Header.Get("Content-Encoding"))
+				gzipReader, err := gzip.NewReader(result.Body)
+				require.NoError(t, err)
+				reader = gzipReader
+			} else {
+				require.Equal(t, "deflate", result.Header.Get("Content-Encoding"))
+				reader = flate.NewReader(result.Body)
+			}
+			body, err := ioutil.ReadAll(reader)
+			require.NoError(t, err)
+			require.True(t, bytes.HasPrefix(body, []byte("deflate")))
+		})
+	}
+}
+
+func TestGinWithZstdLevelsHandler(t *testing.T) {
+	for i := ZstdSpeedFastest; i <= ZstdSpeedBestCompression; i++ {
+		var seq = "zstd_level_" + strconv.Itoa(i)
+		i := i
+		t.Run(seq, func(t *testing.T) {
+			g := newEchoGinInstance(bigPayload, NewHandler(Config{
+				CompressionLevel:     DefaultCompression,
+				ZstdCompressionLevel: i,
+				ZstdWindowSize:       1 << 10,
+				MinContentLength:     1,
+			}).Gin)
+
+			var (
+				w = httptest.NewRecorder()
+				r = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(seq))
+			)
+
+			r.Header.Set("Accept-Encoding", "zstd")
+			g.ServeHTTP(w, r)
+
+			result := w.Result()
+			require.EqualValues(t, http.StatusOK, result.StatusCode)
+			require.Equal(t, "zstd", result.Header.Get("Content-Encoding"))
+			comp, err := ioutil.ReadAll(result.Body)
+			require.NoError(t, err)
+			reader, err := zstd.NewReader(bytes.NewReader(comp))
+			require.NoError(t, err)
+			defer reader.Close()
+			body, err := ioutil.ReadAll(reader)
+			require.NoError(t, err)
+			require.True(t, bytes.HasPrefix(body, []byte(seq)))
+			t.Logf("%s: compressed %d => %d", seq, len(body), len(comp))
+		})
+	}
+}
+
+func TestHTTPWithZstdWindowSizeUnset(t *testing.T) {
+	var (
+		payload              = bytes.Repeat(bigPayload, 1<<20/len(bigPayload))
+		handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Type", "text/plain; charset=utf8")
+			_, _ = w.Write(payload)
+		})
+		r = httptest.NewRequest(http.MethodGet, "/", nil)
+		w = httptest.NewRecorder()
+	)
+
+	r.Header.Set("Accept-Encoding", "zstd")
+	NewHandler(Config{
+		CompressionLevel:     DefaultCompression,
+		ZstdCompressionLevel: ZstdSpeedBestCompression,
+		MinContentLength:     1,
+	}).WrapHandler(handler).ServeHTTP(w, r)
+
+	result := w.Result()
+	require.EqualValues(t, http.StatusOK, result.StatusCode)
+	require.Equal(t, "zstd", result.Header.Get("Content-Encoding"))
+	comp, err := ioutil.ReadAll(result.Body)
+	require.NoError(t, err)
+	var header zstd.Header
+	require.NoError(t, header.Decode(comp))
+	assert.EqualValues(t, defaultZstdWindowSize, header.WindowSize)
+}
+
+func TestGinWithBrotliLevelsHandler(t *testing.T) {
+	for i := BrotliBestSpeed + 1; i <= BrotliBestCompression; i++ {
+		var seq = "brotli_level_" + strconv.Itoa(i)
+		i := i
+		t.Run(seq, func(t *testing.T) {
+			g := newEchoGinInstance(bigPayload, NewHandler(Config{
+				CompressionLevel:       DefaultCompression,
+				BrotliCompressionLevel: i,
+				MinContentLength:       1,
+			}).Gin)
+
+			var (
+				w = httptest.NewRecorder()
+				r = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(seq))
+			)
+
+			r.Header.Set("Accept-Encoding", "br")
+			g.ServeHTTP(w, r)
+
+			result := w.Result()
+			require.EqualValues(t, http.StatusOK, result.StatusCode)
+			require.Equal(t, "br", result.Header.Get("Content-Encoding"))
+			comp, err := ioutil.ReadAll(result.Body)
+			require.NoError(t, err)
+			body, err := ioutil.ReadAll(brotli.NewReader(bytes.NewReader(comp)))
+			require.NoError(t, err)
+			require.True(t, bytes.HasPrefix(body, []byte(seq)))
+			t.Logf("%s: compressed %d => %d", seq, len(body), len(comp))
+		})
+	}
+}
+
+func TestGinWithBrotliLevelUnset(t *testing.T) {
+	compress := func(level int) []byte {
+		g := newEchoGinInstance(bigPayload, NewHandler(Config{
+			CompressionLevel:       DefaultCompression,
+			BrotliCompressionLevel: level,
+			MinContentLength:       1,
+		}).Gin)
+
+		var (
+			w = httptest.NewRecorder()
+			r = httptest.NewRequest(http.MethodPost, "/", strings.NewReader("brotli_level_unset"))
+		)
+
+		r.Header.Set("Accept-Encoding", "br")
+		g.ServeHTTP(w, r)
+
+		result := w.Result()
+		require.EqualValues(t, http.StatusOK, result.StatusCode)
+		require.Equal(t, "br", result.Header.Get("Content-Encoding"))
+		comp, err := ioutil.ReadAll(result.Body)
+		require.NoError(t, err)
+		return comp
+	}
+
+	assert.Equal(t, compress(defaultBrotliCompressionLevel), compress(0))
+}
+
+func TestHTTPWithDefaultHandler_Streaming(t *testing.T) {
+	var (
+		handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Type", "text/plain; charset=utf8")
+			for i := 0; i < 3; i++ {
+				_, _ = io.WriteString(w, "part "+strconv.Itoa(i)+"\n")
+				w.(http.Flusher).Flush()
+			}
+		})
+		r = httptest.NewRequest(http.MethodGet, "/", nil)
+		w = httptest.NewRecorder()
+	)
+
+	r.Header.Set("Accept-Encoding", "gzip")
+	handler = DefaultHandler().WrapHandler(handler)
+
+	handler.ServeHTTP(w, r)
+
+	result := w.Result()
+	assert.EqualValues(t, http.StatusOK, result.StatusCode)
+	assert.True(t, w.Flushed)
+	require.Equal(t, "gzip", result.Header.Get("Content-Encoding"))
+
+	reader, err := gzip.NewReader(result.Body)
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "part 0\npart 1\npart 2\n", string(body))
+}
+
+func TestHTTPWithDefaultHandler_EventStream(t *testing.T) {
+	for _, encoding := range []string{"gzip", "br", "zstd"} {
+		encoding := encoding
+		t.Run(encoding, func(t *testing.T) {
+			var (
+				handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+					w.Header().Set("Content-Type", "text/event-stream")
+					w.Header().Set("Cache-Control", "no-cache")
+					for i := 0; i < 3; i++ {
+						_, _ = io.WriteString(w, "data: "+strconv.Itoa(i)+"\n\n")
+					}
+				})
+				r = httptest.NewRequest(http.MethodGet, "/", nil)
+				w = httptest.NewRecorder()
+			)
+
+			r.Header.Set("Accept-Encoding", encoding)
+			handler = DefaultHandler().WrapHandler(handler)
+
+			handler.ServeHTTP(w, r)
+
+			result := w.Result()
+			assert.EqualValues(t, http.StatusOK, result.StatusCode)
+			assert.True(t, w.Flushed)
+			require.Equal(t, encoding, result.Header.Get("Content-Encoding"))
+
+			var reader io.Reader
+			switch encoding {
+			case "br":
+				reader = brotli.NewReader(result.Body)
+			case "zstd":
+				zstdReader, err := zstd.NewReader(result.Body)
+				require.NoError(t, err)
+				defer zstdReader.Close()
+				reader = zstdReader
+			default:
+				gzipReader, err := gzip.NewReader(result.Body)
+				require.NoError(t, err)
+				reader = gzipReader
+			}
+			body, err := ioutil.ReadAll(reader)
+			require.NoError(t, err)
+			assert.Equal(t, "data: 0\n\ndata: 1\n\ndata: 2\n\n", string(body))
+		})
+	}
+}
+
+func TestHTTPWithDefaultHandler_ResponseController(t *testing.T) {
+	handler := DefaultHandler().WrapHandler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		controller := http.NewResponseController(w)
+		if err := controller.SetWriteDeadline(time.Now().Add(time.Minute)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf8")
+		_, _ = io.WriteString(w, "part 1\n")
+		if err := controller.Flush(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_, _ = io.WriteString(w, "part 2\n")
+	}))
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	r, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+	r.Header.Set("Accept-Encoding", "gzip")
+
+	result, err := server.Client().Do(r)
+	require.NoError(t, err)
+	defer result.Body.Close()
+
+	require.EqualValues(t, http.StatusOK, result.StatusCode)
+	require.Equal(t, "gzip", result.Header.Get("Content-Encoding"))
+
+	reader, err := gzip.NewReader(result.Body)
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "part 1\npart 2\n", string(body))
+}
+
+func TestHTTPWithDefaultHandler_Hijack(t *testing.T) {
+	handler := DefaultHandler().WrapHandler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		hijacker, ok := w.(http.Hijacker)
+		if !ok {
+			http.Error(w, "not a http.Hijacker", http.StatusInternalServerError)
+			return
+		}
+
+		conn, rw, err := hijacker.Hijack()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer conn.Close()
+
+		_, _ = rw.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 8\r\nConnection: close\r\n\r\nhijacked")
+		_ = rw.Flush()
+	}))
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	r, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+	r.Header.Set("Accept-Encoding", "gzip")
+
+	result, err := server.Client().Do(r)
+	require.NoError(t, err)
+	defer result.Body.Close()
+
+	body, err := ioutil.ReadAll(result.Body)
+	require.NoError(t, err)
+	assert.EqualValues(t, http.StatusOK, result.StatusCode)
+	assert.Empty(t, result.Header.Get("Content-Encoding"))
+	assert.Equal(t, "hijacked", string(body))
+}
+
+func TestHTTPWithDefaultHandler_EarlyHints(t *testing.T) {
+	handler := DefaultHandler().WrapHandler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Link", "</style.css>; rel=preload; as=style")
+		w.WriteHeader(http.StatusEarlyHints)
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf8")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(bigPayload)
+	}))
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	var earlyHints []string
+	trace := &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			if code == http.StatusEarlyHints {
+				earlyHints = append(earlyHints, header.Get("Link"))
+			}
+			return nil
+		},
+	}
+
+	r, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+	r = r.WithContext(httptrace.WithClientTrace(r.Context(), trace))
+	r.Header.Set("Accept-Encoding", "gzip")
+
+	result, err := server.Client().Do(r)
+	require.NoError(t, err)
+	defer result.Body.Close()
+
+	assert.Equal(t, []string{"</style.css>; rel=preload; as=style"}, earlyHints)
+	require.EqualValues(t, http.StatusOK, result.StatusCode)
+	require.Equal(t, "gzip", result.Header.Get("Content-Encoding"))
+
+	reader, err := gzip.NewReader(result.Body)
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, bigPayload, body)
+}
+
+func TestHTTPWithTrailers(t *testing.T) {
+	config := defaultConfig
+	config.SizeTrailer = "x-uncompressed-size"
+	config.ChecksumTrailer = "X-Uncompressed-Crc32"
+	handler := NewHandler(config).WrapHandler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Trailer", "X-Declared")
+		w.Header().Set("Content-Type", "text/plain; charset=utf8")
+		_, _ = w.Write(bigPayload)
+		w.Header().Set("X-Declared", "declared")
+		w.Header().Set(http.TrailerPrefix+"X-Undeclared", "undeclared")
+	}))
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	r, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+	r.Header.Set("Accept-Encoding", "gzip")
+
+	result, err := server.Client().Do(r)
+	require.NoError(t, err)
+	defer result.Body.Close()
+
+	require.EqualValues(t, http.StatusOK, result.StatusCode)
+	require.Equal(t, "gzip", result.Header.Get("Content-Encoding"))
+
+	reader, err := gzip.NewReader(result.Body)
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, bigPayload, body)
+
+	// trailers are available after body is read till EOF
+	_, err = io.Copy(ioutil.Discard, result.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "declared", result.Trailer.Get("X-Declared"))
+	assert.Equal(t, "undeclared", result.Trailer.Get("X-Undeclared"))
+	assert.Equal(t, strconv.Itoa(len(bigPayload)), result.Trailer.Get("X-Uncompressed-Size"))
+	assert.Equal(t, fmt.Sprintf("%08x", crc32.ChecksumIEEE(bigPayload)), result.Trailer.Get("X-Uncompressed-Crc32"))
+}
+
+func TestHTTPWithTrailers_not_compressed(t *testing.T) {
+	config := defaultConfig
+	config.SizeTrailer = "X-Uncompressed-Size"
+	handler := NewHandler(config).WrapHandler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Trailer", "X-Declared")
+		w.Header().Set("Content-Type", "text/plain; charset=utf8")
+		_, _ = w.Write(smallPayload)
+		w.Header().Set("X-Declared", "declared")
+	}))
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	r, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+	r.Header.Set("Accept-Encoding", "gzip")
+
+	result, err := server.Client().Do(r)
+	require.NoError(t, err)
+	defer result.Body.Close()
+
+	body, err := ioutil.ReadAll(result.Body)
+	require.NoError(t, err)
+	assert.Empty(t, result.Header.Get("Content-Encoding"))
+	assert.Equal(t, smallPayload, body)
+	assert.Equal(t, "declared", result.Trailer.Get("X-Declared"))
+	assert.Empty(t, result.Trailer.Get("X-Uncompressed-Size"))
+}
+
+func TestHTTPWithDefaultHandler_NoTransform(t *testing.T) {
+	handler := DefaultHandler().WrapHandler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Cache-Control", "no-transform")
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(bigPayload)
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	assert.EqualValues(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Equal(t, bigPayload, w.Body.Bytes())
+}
+
+func TestHTTPWithResponseFilter(t *testing.T) {
+	config := defaultConfig
+	config.ResponseFilter = []ResponseFilter{
+		AndResponseFilter(
+			AdaptRequestFilter(NewPathFilter([]string{"/api/"}, nil)),
+			ResponseFilterFunc(func(req *http.Request, statusCode int, _ http.Header) bool {
+				return statusCode >= 200 && statusCode <= 299 && req.Header.Get("Authorization") != ""
+			}),
+		),
+	}
+	handler := NewHandler(config).WrapHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("missing") != "" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		_, _ = w.Write(bigPayload)
+	}))
+
+	tests := []struct {
+		name       string
+		target     string
+		authorized bool
+		want       bool
+	}{
+		{"authorized api", "/api/users", true, true},
+		{"unauthorized api", "/api/users", false, false},
+		{"not api", "/users", true, false},
+		{"not 2xx", "/api/users?missing=1", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			r.Header.Set("Accept-Encoding", "gzip")
+			if tt.authorized {
+				r.Header.Set("Authorization", "Bearer token")
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if tt.want {
+				assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+				assert.Less(t, w.Body.Len(), len(bigPayload))
+			} else {
+				assert.Empty(t, w.Header().Get("Content-Encoding"))
+				assert.Equal(t, bigPayload, w.Body.Bytes())
+			}
+		})
+	}
+}
+
+func TestHTTPWithStatusCodes(t *testing.T) {
+	config := defaultConfig
+	config.CompressStatusCodes = []StatusCodeRange{{200, 299}, {400, 499}}
+	config.SkipStatusCodes = append(DefaultSkipStatusCodes(), StatusCodeRange{300, 399})
+	handler := NewHandler(config).WrapHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		statusCode, _ := strconv.Atoi(r.URL.Query().Get("status"))
+		w.Header().Set("Content-Type", "text/plain; charset=utf8")
+		w.WriteHeader(statusCode)
+		_, _ = w.Write(bigPayload)
+	}))
+
+	tests := []struct {
+		statusCode int
+		want       bool
+	}{
+		{http.StatusOK, true},
+		{http.StatusCreated, true},
+		{http.StatusFound, false},
+		{http.StatusNotFound, true},
+		{http.StatusBadGateway, false},
+	}
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.statusCode), func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/?status="+strconv.Itoa(tt.statusCode), nil)
+			r.Header.Set("Accept-Encoding", "gzip")
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			assert.EqualValues(t, tt.statusCode, w.Code)
+			if tt.want {
+				assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+				assert.Less(t, w.Body.Len(), len(bigPayload))
+			} else {
+				assert.Empty(t, w.Header().Get("Content-Encoding"))
+				assert.Equal(t, bigPayload, w.Body.Bytes())
+			}
+		})
+	}
+}
+
 func TestHTTPWithDefaultHandler_TinyPayload_WriteTwice(t *testing.T) {
 	var (
 		handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
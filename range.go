@@ -0,0 +1,28 @@
+package gzip
+
+// RangePolicy decides how Handler deals with Range requests.
+//
+// Responses of 206 Partial Content, including multipart/byteranges,
+// are never compressed, since their Content-Range refers to
+// offsets of the uncompressed representation.
+type RangePolicy int
+
+const (
+	// RangePassThrough leaves Range requests to the handler,
+	// compressed 200 responses keep their Accept-Ranges.
+	RangePassThrough RangePolicy = iota
+	// RangeStripAcceptRanges removes Accept-Ranges from compressed 200 responses,
+	// so that clients do not issue Range requests against the compressed body.
+	RangeStripAcceptRanges
+	// RangeServeCompressed serves Range requests from Config.Cache,
+	// applying the range to the cached compressed body with http.ServeContent.
+	//
+	// Range requests missing the cache are left to the handler,
+	// as with RangePassThrough.
+	RangeServeCompressed
+)
+
+// isValid tells whether p is a known RangePolicy
+func (p RangePolicy) isValid() bool {
+	return p >= RangePassThrough && p <= RangeServeCompressed
+}
@@ -0,0 +1,197 @@
+package gzip
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var rangeModTime = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func newRangeInstance(config Config) http.Handler {
+	return NewHandler(config).WrapHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "speech.txt", rangeModTime, bytes.NewReader(bigPayload))
+	}))
+}
+
+func TestRangePassThrough_partial_content(t *testing.T) {
+	tests := []struct {
+		name      string
+		byteRange string
+	}{
+		{
+			name:      "single range",
+			byteRange: "bytes=0-99",
+		},
+		{
+			name:      "multiple ranges",
+			byteRange: "bytes=0-9,20-29",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := newRangeInstance(defaultConfig)
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Accept-Encoding", "gzip")
+			r.Header.Set("Range", tt.byteRange)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			result := w.Result()
+			assert.EqualValues(t, http.StatusPartialContent, result.StatusCode)
+			assert.Empty(t, result.Header.Get("Content-Encoding"))
+			if !strings.Contains(tt.byteRange, ",") {
+				assert.Equal(t, "bytes 0-99/"+strconv.Itoa(len(bigPayload)), result.Header.Get("Content-Range"))
+				assert.Equal(t, bigPayload[:100], w.Body.Bytes())
+			}
+		})
+	}
+}
+
+func TestRangePassThrough_keeps_accept_ranges(t *testing.T) {
+	handler := newRangeInstance(defaultConfig)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "bytes", w.Header().Get("Accept-Ranges"))
+}
+
+func TestRangeStripAcceptRanges(t *testing.T) {
+	config := defaultConfig
+	config.RangePolicy = RangeStripAcceptRanges
+	handler := newRangeInstance(config)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	assert.Empty(t, w.Header().Get("Accept-Ranges"))
+
+	// not compressed
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "bytes", w.Header().Get("Accept-Ranges"))
+}
+
+func TestRangeServeCompressed(t *testing.T) {
+	config := defaultConfig
+	config.RangePolicy = RangeServeCompressed
+	config.Cache = NewCache(1<<20, 0)
+	handler := newRangeInstance(config)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	require.EqualValues(t, http.StatusOK, w.Code)
+	require.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	compressed := w.Body.Bytes()
+
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	r.Header.Set("Range", "bytes=10-")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	result := w.Result()
+	assert.EqualValues(t, http.StatusPartialContent, result.StatusCode)
+	assert.Equal(t, "gzip", result.Header.Get("Content-Encoding"))
+	assert.Equal(t, "text/plain; charset=utf-8", result.Header.Get("Content-Type"))
+	assert.Equal(t, "bytes", result.Header.Get("Accept-Ranges"))
+	assert.Equal(t, "bytes 10-"+strconv.Itoa(len(compressed)-1)+"/"+strconv.Itoa(len(compressed)), result.Header.Get("Content-Range"))
+	assert.Equal(t, compressed[10:], w.Body.Bytes())
+
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	r.Header.Set("Range", "bytes=0-9")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	assert.EqualValues(t, http.StatusPartialContent, w.Code)
+	body := append(w.Body.Bytes(), compressed[10:]...)
+	reader, err := gzip.NewReader(bytes.NewReader(body))
+	require.NoError(t, err)
+	uncompressed, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, bigPayload, uncompressed)
+	assert.EqualValues(t, 2, config.Cache.Stats().Hits)
+}
+
+func TestRangeServeCompressed_cache_miss(t *testing.T) {
+	config := defaultConfig
+	config.RangePolicy = RangeServeCompressed
+	config.Cache = NewCache(1<<20, 0)
+	handler := NewHandler(config).WrapHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, r.URL.Path, rangeModTime, bytes.NewReader(bigPayload))
+	}))
+
+	tests := []struct {
+		name      string
+		path      string
+		byteRange string
+		wantType  string
+	}{
+		{
+			name:      "single range",
+			path:      "/speech.txt",
+			byteRange: "bytes=0-9",
+			wantType:  "text/plain; charset=utf-8",
+		},
+		{
+			name:      "multiple ranges",
+			path:      "/speech.png",
+			byteRange: "bytes=0-1,5-6",
+			wantType:  "multipart/byteranges",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			r.Header.Set("Accept-Encoding", "gzip")
+			r.Header.Set("Range", tt.byteRange)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			assert.EqualValues(t, http.StatusPartialContent, w.Code)
+			assert.Empty(t, w.Header().Get("Content-Encoding"))
+			assert.True(t, strings.HasPrefix(w.Header().Get("Content-Type"), tt.wantType), w.Header().Get("Content-Type"))
+			if !strings.Contains(tt.byteRange, ",") {
+				assert.Equal(t, "bytes 0-9/"+strconv.Itoa(len(bigPayload)), w.Header().Get("Content-Range"))
+				assert.Equal(t, bigPayload[:10], w.Body.Bytes())
+			}
+		})
+	}
+}
+
+func Test_writerWrapper_multipart_byteranges(t *testing.T) {
+	wrapper, recorder := newWrapper()
+
+	wrapper.Header().Set("Content-Type", "multipart/byteranges; boundary=3d6b6a416f9b5")
+	_, err := wrapper.Write(bigPayload)
+	require.NoError(t, err)
+	wrapper.FinishWriting()
+
+	assert.False(t, wrapper.shouldCompress)
+	assert.Empty(t, recorder.Header().Get("Content-Encoding"))
+	assert.Equal(t, bigPayload, recorder.Body.Bytes())
+}
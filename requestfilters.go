@@ -1,6 +1,7 @@
 package gzip
 
 import (
+	"fmt"
 	"net/http"
 	"path"
 	"strings"
@@ -19,10 +20,56 @@ type RequestFilter interface {
 var (
 	_ RequestFilter = (*CommonRequestFilter)(nil)
 	_ RequestFilter = (*ExtensionFilter)(nil)
+	_ RequestFilter = (*NoTransformRequestFilter)(nil)
+	_ RequestFilter = (*PathFilter)(nil)
+	_ RequestFilter = RequestFilterFunc(nil)
 )
 
+// RequestFilterFunc adapts a function into RequestFilter
+type RequestFilterFunc func(req *http.Request) bool
+
+// ShouldCompress implements RequestFilter interface
+func (f RequestFilterFunc) ShouldCompress(req *http.Request) bool {
+	return f(req)
+}
+
+// AndRequestFilter returns a RequestFilter permitting compression
+// when all of filters permit, it's true for no filters.
+func AndRequestFilter(filters ...RequestFilter) RequestFilter {
+	return RequestFilterFunc(func(req *http.Request) bool {
+		for _, filter := range filters {
+			if !filter.ShouldCompress(req) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// OrRequestFilter returns a RequestFilter permitting compression
+// when any of filters permits, it's false for no filters.
+func OrRequestFilter(filters ...RequestFilter) RequestFilter {
+	return RequestFilterFunc(func(req *http.Request) bool {
+		for _, filter := range filters {
+			if filter.ShouldCompress(req) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// NotRequestFilter returns a RequestFilter inverting filter
+func NotRequestFilter(filter RequestFilter) RequestFilter {
+	return RequestFilterFunc(func(req *http.Request) bool {
+		return !filter.ShouldCompress(req)
+	})
+}
+
 // CommonRequestFilter judge via common easy criteria like
 // http method, accept-encoding header, etc.
+//
+// The content-coding is negotiated by Handler afterwards.
 type CommonRequestFilter struct{}
 
 // NewCommonRequestFilter ...
@@ -35,15 +82,38 @@ func (c *CommonRequestFilter) ShouldCompress(req *http.Request) bool {
 	return req.Method != http.MethodHead &&
 		req.Method != http.MethodOptions &&
 		req.Header.Get("Upgrade") == "" &&
-		strings.Contains(req.Header.Get("Accept-Encoding"), "gzip")
+		ParseAcceptEncoding(req.Header).AcceptsCompression()
+}
+
+// NoTransformRequestFilter skips requests marked `Cache-Control: no-transform`,
+// by which the client asks intermediaries not to transform the response.
+//
+// https://www.rfc-editor.org/rfc/rfc9111#section-5.2.1.6
+type NoTransformRequestFilter struct{}
+
+// NewNoTransformRequestFilter ...
+func NewNoTransformRequestFilter() *NoTransformRequestFilter {
+	return &NoTransformRequestFilter{}
+}
+
+// ShouldCompress implements RequestFilter interface
+func (n *NoTransformRequestFilter) ShouldCompress(req *http.Request) bool {
+	return !hasNoTransform(req.Header)
 }
 
 // ExtensionFilter judge via the extension in path
 //
+// By default it's an allow-list, only requests of matched extensions are compressed.
+// In deny-list mode, see NewExtensionDenyFilter(),
+// requests of listed extensions are not compressed while the others are.
+//
 // Omit this filter if you want to compress all extension.
 type ExtensionFilter struct {
 	Exts       *acascii.Matcher
 	AllowEmpty bool
+	// lower-cased denied extensions in deny-list mode,
+	// in which Exts is not used
+	denied map[string]struct{}
 }
 
 // NewExtensionFilter returns a extension or panics
@@ -67,12 +137,42 @@ func NewExtensionFilter(extensions []string) *ExtensionFilter {
 	}
 }
 
+// NewExtensionDenyFilter returns a deny-list extension filter,
+// requests of extensions listed are not compressed, while others are.
+//
+// Extensions are matched exactly and case-insensitively.
+// Requests without extension are compressed unless "" is in extensions.
+//
+// e.g. extend the default deny list:
+//
+//	NewExtensionDenyFilter(append(DefaultDeniedExtensions(), ".foo"))
+func NewExtensionDenyFilter(extensions []string) *ExtensionFilter {
+	filter := &ExtensionFilter{
+		AllowEmpty: true,
+		denied:     make(map[string]struct{}, len(extensions)),
+	}
+
+	for _, item := range extensions {
+		if item == "" {
+			filter.AllowEmpty = false
+			continue
+		}
+		filter.denied[strings.ToLower(item)] = struct{}{}
+	}
+
+	return filter
+}
+
 // ShouldCompress implements RequestFilter interface
 func (e *ExtensionFilter) ShouldCompress(req *http.Request) bool {
 	ext := path.Ext(req.URL.Path)
 	if ext == "" {
 		return e.AllowEmpty
 	}
+	if e.denied != nil {
+		_, denied := e.denied[strings.ToLower(ext)]
+		return !denied
+	}
 	return e.Exts.MatchString(ext)
 }
 
@@ -86,3 +186,171 @@ var defaultExtensions = []string{"", ".txt", ".htm", ".html", ".css", ".php", ".
 func DefaultExtensionFilter() *ExtensionFilter {
 	return NewExtensionFilter(defaultExtensions)
 }
+
+// defaultDeniedExtensions is the list of default extensions
+// of files which are already compressed or barely compressible.
+var defaultDeniedExtensions = []string{
+	".png", ".jpg", ".jpeg", ".gif", ".webp", ".avif", ".heic", ".heif", ".jxl",
+	".mp4", ".m4v", ".webm", ".mkv", ".mov", ".avi", ".flv",
+	".mp3", ".m4a", ".aac", ".ogg", ".oga", ".opus", ".flac",
+	".woff", ".woff2",
+	".zip", ".gz", ".tgz", ".bz2", ".xz", ".zst", ".br", ".7z", ".rar",
+	".jar", ".apk", ".docx", ".xlsx", ".pptx",
+}
+
+// DefaultDeniedExtensions returns a copy of the default deny list of extensions,
+// which can be extended and passed to NewExtensionDenyFilter().
+func DefaultDeniedExtensions() []string {
+	return append([]string(nil), defaultDeniedExtensions...)
+}
+
+// DefaultExtensionDenyFilter compresses requests of all extensions
+// except those in DefaultDeniedExtensions()
+func DefaultExtensionDenyFilter() *ExtensionFilter {
+	return NewExtensionDenyFilter(defaultDeniedExtensions)
+}
+
+// PathFilter judge via the path of request
+//
+// Patterns are matched against the cleaned path:
+//
+//	/metrics          matches exactly /metrics
+//	/static/          matches /static/ and any path under it
+//	/api/*/export     "*", "?" and "[...]" match within a segment, see path.Match
+//	/api/export/**    "**" matches zero or more segments
+//
+// A path matching any of excludes is not compressed,
+// otherwise it's compressed if includes is empty or any of includes matches.
+type PathFilter struct {
+	includes []pathPattern
+	excludes []pathPattern
+}
+
+// pathPattern is a compiled pattern of PathFilter
+type pathPattern struct {
+	pattern string
+	// prefix or exact path if segments is nil
+	prefix   bool
+	segments []string
+}
+
+// NewPathFilter returns a path filter or panics on malformed pattern
+func NewPathFilter(includes, excludes []string) *PathFilter {
+	return &PathFilter{
+		includes: compilePathPatterns(includes),
+		excludes: compilePathPatterns(excludes),
+	}
+}
+
+func compilePathPatterns(patterns []string) []pathPattern {
+	compiled := make([]pathPattern, 0, len(patterns))
+	for _, pattern := range patterns {
+		compiled = append(compiled, compilePathPattern(pattern))
+	}
+
+	return compiled
+}
+
+func compilePathPattern(pattern string) pathPattern {
+	if !strings.HasPrefix(pattern, "/") {
+		panic(fmt.Sprintf("gzip: path pattern must start with /: %q", pattern))
+	}
+
+	if !strings.ContainsAny(pattern, "*?[\\") {
+		return pathPattern{
+			pattern: pattern,
+			prefix:  strings.HasSuffix(pattern, "/"),
+		}
+	}
+
+	segments := strings.Split(pattern, "/")
+	for _, segment := range segments {
+		if segment == "**" {
+			continue
+		}
+		if _, err := path.Match(segment, ""); err != nil {
+			panic(fmt.Sprintf("gzip: malformed path pattern %q: %s", pattern, err))
+		}
+	}
+
+	return pathPattern{
+		pattern:  pattern,
+		segments: segments,
+	}
+}
+
+// match tells whether the cleaned urlPath matches p,
+// segments are urlPath split by "/" when p is a glob.
+func (p pathPattern) match(urlPath string, segments []string) bool {
+	if p.segments == nil {
+		if p.prefix {
+			return strings.HasPrefix(urlPath, p.pattern) || urlPath+"/" == p.pattern
+		}
+		return urlPath == p.pattern
+	}
+
+	return matchSegments(p.segments, segments)
+}
+
+func matchSegments(pattern, segments []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			rest := pattern[1:]
+			if len(rest) == 0 {
+				return true
+			}
+			for i := 0; i <= len(segments); i++ {
+				if matchSegments(rest, segments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(segments) == 0 {
+			return false
+		}
+		if matched, _ := path.Match(pattern[0], segments[0]); !matched {
+			return false
+		}
+		pattern, segments = pattern[1:], segments[1:]
+	}
+
+	return len(segments) == 0
+}
+
+// ShouldCompress implements RequestFilter interface
+func (p *PathFilter) ShouldCompress(req *http.Request) bool {
+	urlPath := req.URL.Path
+	if urlPath == "" {
+		urlPath = "/"
+	}
+	urlPath = path.Clean(urlPath)
+	if strings.HasSuffix(req.URL.Path, "/") && urlPath != "/" {
+		urlPath += "/"
+	}
+
+	var segments []string
+	for _, pattern := range p.excludes {
+		if pattern.segments != nil && segments == nil {
+			segments = strings.Split(urlPath, "/")
+		}
+		if pattern.match(urlPath, segments) {
+			return false
+		}
+	}
+
+	if len(p.includes) == 0 {
+		return true
+	}
+	for _, pattern := range p.includes {
+		if pattern.segments != nil && segments == nil {
+			segments = strings.Split(urlPath, "/")
+		}
+		if pattern.match(urlPath, segments) {
+			return true
+		}
+	}
+
+	return false
+}
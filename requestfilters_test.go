@@ -4,6 +4,8 @@ import (
 	"net/http"
 	"net/url"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestCommonCaseFilter_ShouldCompress(t *testing.T) {
@@ -17,6 +19,16 @@ func TestCommonCaseFilter_ShouldCompress(t *testing.T) {
 			req:  &http.Request{Method: http.MethodPost, Header: map[string][]string{"Accept-Encoding": {"gzip"}}},
 			want: true,
 		},
+		{
+			name: "Brotli request",
+			req:  &http.Request{Method: http.MethodPost, Header: map[string][]string{"Accept-Encoding": {"br"}}},
+			want: true,
+		},
+		{
+			name: "Zstd request",
+			req:  &http.Request{Method: http.MethodPost, Header: map[string][]string{"Accept-Encoding": {"zstd"}}},
+			want: true,
+		},
 		{
 			name: "HEAD request",
 			req:  &http.Request{Method: http.MethodHead, Header: map[string][]string{"Accept-Encoding": {"gzip"}}},
@@ -27,6 +39,16 @@ func TestCommonCaseFilter_ShouldCompress(t *testing.T) {
 			req:  &http.Request{Method: http.MethodPost, Header: map[string][]string{"Accept-Encoding": {"gzip"}, "Upgrade": {"http2"}}},
 			want: false,
 		},
+		{
+			name: "Refusing gzip request",
+			req:  &http.Request{Method: http.MethodPost, Header: map[string][]string{"Accept-Encoding": {"gzip;q=0"}}},
+			want: false,
+		},
+		{
+			name: "Identity only request",
+			req:  &http.Request{Method: http.MethodPost, Header: map[string][]string{"Accept-Encoding": {"identity"}}},
+			want: false,
+		},
 		{
 			name: "Not accepting gzip request",
 			req:  &http.Request{Method: http.MethodPost},
@@ -80,6 +102,158 @@ func TestExtensionFilter_ShouldCompress(t *testing.T) {
 	}
 }
 
+func TestExtensionDenyFilter_ShouldCompress(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter *ExtensionFilter
+		path   string
+		want   bool
+	}{
+		{"no ext", DefaultExtensionDenyFilter(), "/hello", true},
+		{"txt", DefaultExtensionDenyFilter(), "/a.txt", true},
+		{"unknown text format", DefaultExtensionDenyFilter(), "/a.toml", true},
+		{"png", DefaultExtensionDenyFilter(), "/a.png", false},
+		{"upper case", DefaultExtensionDenyFilter(), "/A.JPG", false},
+		{"not a substring match", DefaultExtensionDenyFilter(), "/a.gzx", true},
+		{"gz", DefaultExtensionDenyFilter(), "/a.tar.gz", false},
+		{"extended", NewExtensionDenyFilter(append(DefaultDeniedExtensions(), ".bin")), "/a.bin", false},
+		{"extended keeps defaults", NewExtensionDenyFilter(append(DefaultDeniedExtensions(), ".bin")), "/a.zip", false},
+		{"deny no ext", NewExtensionDenyFilter([]string{"", ".bin"}), "/hello", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &http.Request{URL: mustParseURL("https://example.com" + tt.path), Method: http.MethodGet}
+			if got := tt.filter.ShouldCompress(req); got != tt.want {
+				t.Errorf("ShouldCompress() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// default deny list is not modified by extending
+	assert.NotContains(t, DefaultDeniedExtensions(), ".bin")
+}
+
+func TestNoTransformRequestFilter_ShouldCompress(t *testing.T) {
+	tests := []struct {
+		name string
+		req  *http.Request
+		want bool
+	}{
+		{
+			name: "no Cache-Control",
+			req:  &http.Request{URL: mustParseURL("https://example.com/hello"), Method: http.MethodGet, Header: map[string][]string{"Accept-Encoding": {"gzip"}}},
+			want: true,
+		},
+		{
+			name: "no-cache",
+			req:  &http.Request{URL: mustParseURL("https://example.com/hello"), Method: http.MethodGet, Header: map[string][]string{"Accept-Encoding": {"gzip"}, "Cache-Control": {"no-cache"}}},
+			want: true,
+		},
+		{
+			name: "no-transform",
+			req:  &http.Request{URL: mustParseURL("https://example.com/hello"), Method: http.MethodGet, Header: map[string][]string{"Accept-Encoding": {"gzip"}, "Cache-Control": {"no-cache, no-transform"}}},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := NewNoTransformRequestFilter()
+			if got := n.ShouldCompress(tt.req); got != tt.want {
+				t.Errorf("ShouldCompress() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPathFilter_ShouldCompress(t *testing.T) {
+	tests := []struct {
+		name     string
+		includes []string
+		excludes []string
+		path     string
+		want     bool
+	}{
+		{"no patterns", nil, nil, "/anything", true},
+		{"exact exclude", nil, []string{"/metrics"}, "/metrics", false},
+		{"exact exclude not prefix", nil, []string{"/metrics"}, "/metrics/cpu", true},
+		{"exact exclude other path", nil, []string{"/metrics"}, "/metricsx", true},
+		{"prefix exclude", nil, []string{"/static/"}, "/static/js/app.js", false},
+		{"prefix exclude itself", nil, []string{"/static/"}, "/static", false},
+		{"prefix exclude other path", nil, []string{"/static/"}, "/staticx/app.js", true},
+		{"double star exclude", nil, []string{"/api/export/**"}, "/api/export/2021/01/report.csv", false},
+		{"double star exclude root", nil, []string{"/api/export/**"}, "/api/export", false},
+		{"double star exclude other path", nil, []string{"/api/export/**"}, "/api/exports", true},
+		{"double star in the middle", nil, []string{"/api/**/raw"}, "/api/a/b/raw", false},
+		{"double star in the middle zero segment", nil, []string{"/api/**/raw"}, "/api/raw", false},
+		{"star within segment", nil, []string{"/api/*/export"}, "/api/users/export", false},
+		{"star not across segments", nil, []string{"/api/*/export"}, "/api/a/b/export", true},
+		{"unclean path", nil, []string{"/admin/**"}, "/public/../admin/users", false},
+		{"include matches", []string{"/api/**"}, nil, "/api/users", true},
+		{"include not matches", []string{"/api/**"}, nil, "/assets/app.js", false},
+		{"deny overrides allow", []string{"/api/**"}, []string{"/api/export/**"}, "/api/export/all", false},
+		{"glob char class", []string{"/v[12]/**"}, nil, "/v2/users", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewPathFilter(tt.includes, tt.excludes)
+			req := &http.Request{URL: mustParseURL("https://example.com" + tt.path), Method: http.MethodGet}
+			if got := p.ShouldCompress(req); got != tt.want {
+				t.Errorf("ShouldCompress() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewPathFilter_Checks(t *testing.T) {
+	assert.NotPanics(t, func() {
+		NewPathFilter([]string{"/api/**"}, []string{"/metrics"})
+	})
+	assert.Panics(t, func() {
+		NewPathFilter([]string{"api/**"}, nil)
+	})
+	assert.Panics(t, func() {
+		NewPathFilter(nil, []string{"/api/[a-"})
+	})
+}
+
+func TestRequestFilterCombinators(t *testing.T) {
+	var (
+		yes = RequestFilterFunc(func(*http.Request) bool { return true })
+		no  = RequestFilterFunc(func(*http.Request) bool { return false })
+		req = &http.Request{URL: mustParseURL("https://example.com/api/users"), Method: http.MethodGet}
+	)
+
+	tests := []struct {
+		name   string
+		filter RequestFilter
+		want   bool
+	}{
+		{"func", yes, true},
+		{"and none", AndRequestFilter(), true},
+		{"and all yes", AndRequestFilter(yes, yes), true},
+		{"and one no", AndRequestFilter(yes, no), false},
+		{"or none", OrRequestFilter(), false},
+		{"or one yes", OrRequestFilter(no, yes), true},
+		{"or all no", OrRequestFilter(no, no), false},
+		{"not", NotRequestFilter(no), true},
+		{
+			"api except export",
+			AndRequestFilter(
+				NewPathFilter([]string{"/api/**"}, nil),
+				NotRequestFilter(NewPathFilter([]string{"/api/export/**"}, nil)),
+			),
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.ShouldCompress(req); got != tt.want {
+				t.Errorf("ShouldCompress() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func mustParseURL(rawurl string) (URL *url.URL) {
 	URL, err := url.Parse(rawurl)
 	if err != nil {
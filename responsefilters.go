@@ -1,7 +1,9 @@
 package gzip
 
 import (
+	"mime"
 	"net/http"
+	"strings"
 
 	"github.com/signalsciences/ac/acascii"
 )
@@ -18,8 +20,119 @@ type ResponseHeaderFilter interface {
 var (
 	_ ResponseHeaderFilter = (*SkipCompressedFilter)(nil)
 	_ ResponseHeaderFilter = (*ContentTypeFilter)(nil)
+	_ ResponseHeaderFilter = (*NoTransformFilter)(nil)
+	_ ResponseHeaderFilter = ResponseHeaderFilterFunc(nil)
+	_ ResponseFilter       = ResponseFilterFunc(nil)
 )
 
+// ResponseHeaderFilterFunc adapts a function into ResponseHeaderFilter
+type ResponseHeaderFilterFunc func(header http.Header) bool
+
+// ShouldCompress implements ResponseHeaderFilter interface
+func (f ResponseHeaderFilterFunc) ShouldCompress(header http.Header) bool {
+	return f(header)
+}
+
+// AndResponseHeaderFilter returns a ResponseHeaderFilter permitting compression
+// when all of filters permit, it's true for no filters.
+func AndResponseHeaderFilter(filters ...ResponseHeaderFilter) ResponseHeaderFilter {
+	return ResponseHeaderFilterFunc(func(header http.Header) bool {
+		for _, filter := range filters {
+			if !filter.ShouldCompress(header) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// OrResponseHeaderFilter returns a ResponseHeaderFilter permitting compression
+// when any of filters permits, it's false for no filters.
+func OrResponseHeaderFilter(filters ...ResponseHeaderFilter) ResponseHeaderFilter {
+	return ResponseHeaderFilterFunc(func(header http.Header) bool {
+		for _, filter := range filters {
+			if filter.ShouldCompress(header) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// NotResponseHeaderFilter returns a ResponseHeaderFilter inverting filter
+func NotResponseHeaderFilter(filter ResponseHeaderFilter) ResponseHeaderFilter {
+	return ResponseHeaderFilterFunc(func(header http.Header) bool {
+		return !filter.ShouldCompress(header)
+	})
+}
+
+// ResponseFilter decide whether or not to compress response
+// judging by request, status code and response header together
+type ResponseFilter interface {
+	// ShouldCompress decide whether or not to compress response of req,
+	// judging by its status code and header.
+	//
+	// statusCode is the final status code of response,
+	// which is 200 if the handler does not set one.
+	ShouldCompress(req *http.Request, statusCode int, header http.Header) bool
+}
+
+// ResponseFilterFunc adapts a function into ResponseFilter
+type ResponseFilterFunc func(req *http.Request, statusCode int, header http.Header) bool
+
+// ShouldCompress implements ResponseFilter interface
+func (f ResponseFilterFunc) ShouldCompress(req *http.Request, statusCode int, header http.Header) bool {
+	return f(req, statusCode, header)
+}
+
+// AdaptResponseHeaderFilter adapts a ResponseHeaderFilter into ResponseFilter
+func AdaptResponseHeaderFilter(filter ResponseHeaderFilter) ResponseFilter {
+	return ResponseFilterFunc(func(_ *http.Request, _ int, header http.Header) bool {
+		return filter.ShouldCompress(header)
+	})
+}
+
+// AdaptRequestFilter adapts a RequestFilter into ResponseFilter,
+// so that it can be combined with response rules.
+func AdaptRequestFilter(filter RequestFilter) ResponseFilter {
+	return ResponseFilterFunc(func(req *http.Request, _ int, _ http.Header) bool {
+		return filter.ShouldCompress(req)
+	})
+}
+
+// AndResponseFilter returns a ResponseFilter permitting compression
+// when all of filters permit, it's true for no filters.
+func AndResponseFilter(filters ...ResponseFilter) ResponseFilter {
+	return ResponseFilterFunc(func(req *http.Request, statusCode int, header http.Header) bool {
+		for _, filter := range filters {
+			if !filter.ShouldCompress(req, statusCode, header) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// OrResponseFilter returns a ResponseFilter permitting compression
+// when any of filters permits, it's false for no filters.
+func OrResponseFilter(filters ...ResponseFilter) ResponseFilter {
+	return ResponseFilterFunc(func(req *http.Request, statusCode int, header http.Header) bool {
+		for _, filter := range filters {
+			if filter.ShouldCompress(req, statusCode, header) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// NotResponseFilter returns a ResponseFilter inverting filter
+func NotResponseFilter(filter ResponseFilter) ResponseFilter {
+	return ResponseFilterFunc(func(req *http.Request, statusCode int, header http.Header) bool {
+		return !filter.ShouldCompress(req, statusCode, header)
+	})
+}
+
 // SkipCompressedFilter judges whether content has been
 // already compressed
 type SkipCompressedFilter struct{}
@@ -36,33 +149,162 @@ func (s *SkipCompressedFilter) ShouldCompress(header http.Header) bool {
 	return header.Get("Content-Encoding") == "" && header.Get("Transfer-Encoding") == ""
 }
 
+// NoTransformFilter skips responses marked `Cache-Control: no-transform`,
+// whose content-coding must not be changed by intermediaries.
+//
+// https://www.rfc-editor.org/rfc/rfc9111#section-5.2.2.6
+type NoTransformFilter struct{}
+
+// NewNoTransformFilter ...
+func NewNoTransformFilter() *NoTransformFilter {
+	return &NoTransformFilter{}
+}
+
+// ShouldCompress implements ResponseHeaderFilter interface
+func (n *NoTransformFilter) ShouldCompress(header http.Header) bool {
+	return !hasNoTransform(header)
+}
+
+// hasNoTransform tells whether Cache-Control of header has no-transform directive
+func hasNoTransform(header http.Header) bool {
+	for _, value := range header["Cache-Control"] {
+		for value != "" {
+			var directive string
+			directive, value, _ = cutString(value, ",")
+			// directive arguments are irrelevant
+			directive, _, _ = cutString(directive, "=")
+			if strings.EqualFold(strings.TrimSpace(directive), "no-transform") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
 // ContentTypeFilter judge via the response content type
 //
+// Content-Type is parsed by mime.ParseMediaType,
+// and its media type is matched against, in a case-insensitive way,
+//
+//	"text/html"        media type exactly, parameters of pattern are ignored
+//	"text/*"           any subtype of a type
+//	"*/*"              any media type
+//	"+json"            any media type with a structured syntax suffix,
+//	                   e.g. "application/vnd.api+json" and "application/problem+json"
+//	"!image/svg+xml"   exception, media types matching it are not matched
+//	                   by other patterns
+//	""                 empty or absent Content-Type
+//
+// By default it's an allow-list, only matched responses are compressed.
+// In deny-list mode, see NewContentTypeDenyFilter(),
+// matched responses are not compressed while the others are.
+//
 // Omit this filter if you want to compress all content type.
 type ContentTypeFilter struct {
+	// Types matches Content-Type by substring.
+	//
+	// Deprecated: constructors match media types instead and leave Types nil.
+	// It's only used by filters built without a constructor,
+	// for which a non-nil Types keeps the former substring matching.
 	Types      *acascii.Matcher
+	matcher    mediaTypeMatcher
+	exceptions mediaTypeMatcher
+	deny       bool
+	// whether response without Content-Type is compressed
 	AllowEmpty bool
 }
 
-// NewContentTypeFilter ...
+// mediaTypeMatcher matches media types, see ContentTypeFilter
+type mediaTypeMatcher struct {
+	// media types, e.g. "text/html"
+	types map[string]struct{}
+	// types of wildcards, e.g. "text" of "text/*"
+	wildcards map[string]struct{}
+	// structured syntax suffixes, e.g. "+json"
+	suffixes []string
+	// whether "*/*" is present
+	any bool
+}
+
+// add adds lower-cased media type pattern
+func (m *mediaTypeMatcher) add(pattern string) {
+	switch {
+	case pattern == "*/*":
+		m.any = true
+	case strings.HasPrefix(pattern, "+"):
+		m.suffixes = append(m.suffixes, pattern)
+	case strings.HasSuffix(pattern, "/*"):
+		if m.wildcards == nil {
+			m.wildcards = make(map[string]struct{})
+		}
+		m.wildcards[strings.TrimSuffix(pattern, "/*")] = struct{}{}
+	default:
+		if m.types == nil {
+			m.types = make(map[string]struct{})
+		}
+		m.types[pattern] = struct{}{}
+	}
+}
+
+// match tells whether lower-cased mediaType matches any of patterns of m
+func (m *mediaTypeMatcher) match(mediaType string) bool {
+	if m.any {
+		return true
+	}
+	if _, ok := m.types[mediaType]; ok {
+		return true
+	}
+
+	if slash := strings.IndexByte(mediaType, '/'); slash >= 0 {
+		if _, ok := m.wildcards[mediaType[:slash]]; ok {
+			return true
+		}
+	}
+	for _, suffix := range m.suffixes {
+		if strings.HasSuffix(mediaType, suffix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NewContentTypeFilter returns an allow-list content type filter,
+// responses matching types are compressed.
 func NewContentTypeFilter(types []string) *ContentTypeFilter {
-	var (
-		nonEmpty   = make([]string, 0, len(types))
-		allowEmpty bool
-	)
+	filter := &ContentTypeFilter{}
 
 	for _, item := range types {
-		if item == "" {
-			allowEmpty = true
-			continue
+		exception := strings.HasPrefix(item, "!")
+		item = mediaTypeOf(strings.TrimPrefix(item, "!"))
+		switch {
+		case item == "":
+			filter.AllowEmpty = !exception
+		case exception:
+			filter.exceptions.add(item)
+		default:
+			filter.matcher.add(item)
 		}
-		nonEmpty = append(nonEmpty, item)
 	}
 
-	return &ContentTypeFilter{
-		Types:      acascii.MustCompileString(nonEmpty),
-		AllowEmpty: allowEmpty,
-	}
+	return filter
+}
+
+// NewContentTypeDenyFilter returns a deny-list content type filter,
+// responses matching types are not compressed, while others are.
+//
+// Responses without Content-Type are compressed unless "" is in types.
+//
+// e.g. extend the default deny list:
+//
+//	NewContentTypeDenyFilter(append(DefaultDeniedContentTypes(), "application/x-foo"))
+func NewContentTypeDenyFilter(types []string) *ContentTypeFilter {
+	filter := NewContentTypeFilter(types)
+	filter.deny = true
+	filter.AllowEmpty = !filter.AllowEmpty
+
+	return filter
 }
 
 // ShouldCompress implements RequestFilter interface
@@ -72,16 +314,52 @@ func (e *ContentTypeFilter) ShouldCompress(header http.Header) bool {
 	if contentType == "" {
 		return e.AllowEmpty
 	}
+	if e.Types != nil {
+		return e.Types.MatchString(contentType)
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil && err != mime.ErrInvalidMediaParameter {
+		// malformed media type
+		return false
+	}
 
-	return e.Types.MatchString(contentType)
+	matched := e.matcher.match(mediaType) && !e.exceptions.match(mediaType)
+	return matched != e.deny
 }
 
 // defaultContentType is the list of default content types for which to enable gzip.
 // original source:
 // https://support.cloudflare.com/hc/en-us/articles/200168396-What-will-Cloudflare-compress-
-var defaultContentType = []string{"text/html", "text/richtext", "text/plain", "text/css", "text/x-script", "text/x-component", "text/x-java-source", "text/x-markdown", "application/javascript", "application/x-javascript", "text/javascript", "text/js", "image/x-icon", "application/x-perl", "application/x-httpd-cgi", "text/xml", "application/xml", "application/xml+rss", "application/json", "multipart/bag", "multipart/mixed", "application/xhtml+xml", "font/ttf", "font/otf", "font/x-woff", "image/svg+xml", "application/vnd.ms-fontobject", "application/ttf", "application/x-ttf", "application/otf", "application/x-otf", "application/truetype", "application/opentype", "application/x-opentype", "application/font-woff", "application/eot", "application/font", "application/font-sfnt", "application/wasm"}
+var defaultContentType = []string{"text/html", "text/richtext", "text/plain", "text/css", "text/x-script", "text/x-component", "text/x-java-source", "text/x-markdown", "application/javascript", "application/x-javascript", "text/javascript", "text/js", "image/x-icon", "application/x-perl", "application/x-httpd-cgi", "text/xml", "application/xml", "application/xml+rss", "application/json", "multipart/bag", "multipart/mixed", "application/xhtml+xml", "font/ttf", "font/otf", "font/x-woff", "image/svg+xml", "application/vnd.ms-fontobject", "application/ttf", "application/x-ttf", "application/otf", "application/x-otf", "application/truetype", "application/opentype", "application/x-opentype", "application/font-woff", "application/eot", "application/font", "application/font-sfnt", "application/wasm", "text/event-stream", "+json", "+xml"}
 
 // DefaultContentTypeFilter permits
 func DefaultContentTypeFilter() *ContentTypeFilter {
 	return NewContentTypeFilter(defaultContentType)
 }
+
+// defaultDeniedContentType is the list of default content types
+// which are already compressed or barely compressible.
+var defaultDeniedContentType = []string{
+	"image/*", "!image/svg+xml", "!image/x-icon", "!image/vnd.microsoft.icon", "!image/bmp",
+	"video/*", "audio/*", "font/woff", "font/woff2", "application/font-woff",
+	"application/zip", "application/gzip", "application/x-gzip", "application/zstd",
+	"application/x-bzip2", "application/x-xz", "application/x-7z-compressed",
+	"application/vnd.rar", "application/x-rar-compressed", "application/java-archive",
+	"application/vnd.android.package-archive",
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation",
+}
+
+// DefaultDeniedContentTypes returns a copy of the default deny list of content types,
+// which can be extended and passed to NewContentTypeDenyFilter().
+func DefaultDeniedContentTypes() []string {
+	return append([]string(nil), defaultDeniedContentType...)
+}
+
+// DefaultContentTypeDenyFilter compresses all content types
+// except those in DefaultDeniedContentTypes()
+func DefaultContentTypeDenyFilter() *ContentTypeFilter {
+	return NewContentTypeDenyFilter(defaultDeniedContentType)
+}
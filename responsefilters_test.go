@@ -2,7 +2,11 @@ package gzip
 
 import (
 	"net/http"
+	"net/http/httptest"
 	"testing"
+
+	"github.com/signalsciences/ac/acascii"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestSkipCompressedFilter_ShouldCompress(t *testing.T) {
@@ -52,6 +56,53 @@ func TestSkipCompressedFilter_ShouldCompress(t *testing.T) {
 	}
 }
 
+func TestNoTransformFilter_ShouldCompress(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		want   bool
+	}{
+		{
+			"no Cache-Control",
+			make(http.Header),
+			true,
+		},
+		{
+			"other directives",
+			http.Header{"Cache-Control": []string{"max-age=60, public"}},
+			true,
+		},
+		{
+			"no-transform",
+			http.Header{"Cache-Control": []string{"no-transform"}},
+			false,
+		},
+		{
+			"no-transform among directives",
+			http.Header{"Cache-Control": []string{"public, No-Transform ,max-age=60"}},
+			false,
+		},
+		{
+			"no-transform in another field line",
+			http.Header{"Cache-Control": []string{"public", "no-transform"}},
+			false,
+		},
+		{
+			"not a directive",
+			http.Header{"Cache-Control": []string{"no-transformation, private"}},
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := NewNoTransformFilter()
+			if got := n.ShouldCompress(tt.header); got != tt.want {
+				t.Errorf("ShouldCompress() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestContentTypeFilter_ShouldCompress(t *testing.T) {
 	tests := []struct {
 		header http.Header
@@ -77,6 +128,14 @@ func TestContentTypeFilter_ShouldCompress(t *testing.T) {
 			contentTypeHeader("image/png"),
 			false,
 		},
+		{
+			contentTypeHeader("application/vnd.api+json"),
+			true,
+		},
+		{
+			contentTypeHeader("application/atom+xml; charset=utf8"),
+			true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.header.Get("Content-Type"), func(t *testing.T) {
@@ -88,6 +147,165 @@ func TestContentTypeFilter_ShouldCompress(t *testing.T) {
 	}
 }
 
+func TestContentTypeFilter_ShouldCompress_deprecated_Types(t *testing.T) {
+	filter := &ContentTypeFilter{
+		Types:      acascii.MustCompileString([]string{"text/", "json"}),
+		AllowEmpty: true,
+	}
+
+	assert.True(t, filter.ShouldCompress(http.Header{"Content-Type": []string{"text/html; charset=utf-8"}}))
+	assert.True(t, filter.ShouldCompress(http.Header{"Content-Type": []string{"application/json"}}))
+	assert.False(t, filter.ShouldCompress(http.Header{"Content-Type": []string{"image/png"}}))
+	assert.True(t, filter.ShouldCompress(http.Header{}))
+	assert.Nil(t, NewContentTypeFilter([]string{"text/html"}).Types)
+}
+
+func TestContentTypeFilter_ShouldCompress_patterns(t *testing.T) {
+	filter := NewContentTypeFilter([]string{"text/html", "Image/*", "+json", "application/xml; charset=utf-8"})
+
+	tests := []struct {
+		contentType string
+		want        bool
+	}{
+		{"", false},
+		{"text/html", true},
+		{"TEXT/HTML; charset=UTF-8", true},
+		{"text/htmlx", false},
+		{"text/plain; note=\"text/html\"", false},
+		{"image/png", true},
+		{"image", false},
+		{"application/json", false},
+		{"application/vnd.api+json", true},
+		{"application/problem+json; charset=utf-8", true},
+		{"application/xml", true},
+		{"application/xml; charset=ascii", true},
+		{"application/json+xml", false},
+		{"text/html; charset", true},
+		{"/html", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.contentType, func(t *testing.T) {
+			if got := filter.ShouldCompress(contentTypeHeader(tt.contentType)); got != tt.want {
+				t.Errorf("ShouldCompress() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	assert.True(t, NewContentTypeFilter([]string{"*/*"}).ShouldCompress(contentTypeHeader("video/mp4")))
+	assert.False(t, NewContentTypeFilter([]string{"*/*"}).ShouldCompress(contentTypeHeader("")))
+	assert.True(t, NewContentTypeFilter([]string{""}).ShouldCompress(contentTypeHeader("")))
+	assert.False(t, NewContentTypeFilter([]string{"text/*", "!text/csv"}).ShouldCompress(contentTypeHeader("text/csv")))
+	assert.True(t, NewContentTypeFilter([]string{"text/*", "!text/csv"}).ShouldCompress(contentTypeHeader("text/plain")))
+}
+
+func TestContentTypeDenyFilter_ShouldCompress(t *testing.T) {
+	tests := []struct {
+		name        string
+		filter      *ContentTypeFilter
+		contentType string
+		want        bool
+	}{
+		{"empty", DefaultContentTypeDenyFilter(), "", true},
+		{"html", DefaultContentTypeDenyFilter(), "text/html; charset=utf-8", true},
+		{"unknown text format", DefaultContentTypeDenyFilter(), "application/toml", true},
+		{"png", DefaultContentTypeDenyFilter(), "image/png", false},
+		{"svg", DefaultContentTypeDenyFilter(), "image/svg+xml", true},
+		{"video", DefaultContentTypeDenyFilter(), "video/mp4", false},
+		{"audio", DefaultContentTypeDenyFilter(), "audio/ogg", false},
+		{"zip", DefaultContentTypeDenyFilter(), "application/zip", false},
+		{"gzip", DefaultContentTypeDenyFilter(), "application/gzip", false},
+		{"malformed", DefaultContentTypeDenyFilter(), "/html", false},
+		{"extended", NewContentTypeDenyFilter(append(DefaultDeniedContentTypes(), "application/x-protobuf")), "application/x-protobuf", false},
+		{"extended keeps defaults", NewContentTypeDenyFilter(append(DefaultDeniedContentTypes(), "application/x-protobuf")), "image/jpeg", false},
+		{"deny empty", NewContentTypeDenyFilter([]string{"", "image/*"}), "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.ShouldCompress(contentTypeHeader(tt.contentType)); got != tt.want {
+				t.Errorf("ShouldCompress() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// default deny list is not modified by extending
+	assert.NotContains(t, DefaultDeniedContentTypes(), "application/x-protobuf")
+}
+
+func TestResponseHeaderFilterCombinators(t *testing.T) {
+	var (
+		yes    = ResponseHeaderFilterFunc(func(http.Header) bool { return true })
+		no     = ResponseHeaderFilterFunc(func(http.Header) bool { return false })
+		header = http.Header{"Content-Type": []string{"text/csv"}, "Cache-Control": []string{"no-transform"}}
+	)
+
+	tests := []struct {
+		name   string
+		filter ResponseHeaderFilter
+		want   bool
+	}{
+		{"func", yes, true},
+		{"and none", AndResponseHeaderFilter(), true},
+		{"and all yes", AndResponseHeaderFilter(yes, yes), true},
+		{"and one no", AndResponseHeaderFilter(yes, no), false},
+		{"or none", OrResponseHeaderFilter(), false},
+		{"or one yes", OrResponseHeaderFilter(no, yes), true},
+		{"or all no", OrResponseHeaderFilter(no, no), false},
+		{"not", NotResponseHeaderFilter(no), true},
+		{
+			"text or json except no-transform",
+			AndResponseHeaderFilter(
+				OrResponseHeaderFilter(
+					NewContentTypeFilter([]string{"text/*"}),
+					NewContentTypeFilter([]string{"+json"}),
+				),
+				NewNoTransformFilter(),
+			),
+			false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.ShouldCompress(header); got != tt.want {
+				t.Errorf("ShouldCompress() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResponseFilterCombinators(t *testing.T) {
+	var (
+		yes    = ResponseFilterFunc(func(*http.Request, int, http.Header) bool { return true })
+		no     = ResponseFilterFunc(func(*http.Request, int, http.Header) bool { return false })
+		isOK   = ResponseFilterFunc(func(_ *http.Request, statusCode int, _ http.Header) bool { return statusCode == http.StatusOK })
+		req    = httptest.NewRequest(http.MethodGet, "/api/users", nil)
+		header = http.Header{"Content-Type": []string{"application/json"}}
+	)
+
+	tests := []struct {
+		name   string
+		filter ResponseFilter
+		want   bool
+	}{
+		{"func", isOK, true},
+		{"and none", AndResponseFilter(), true},
+		{"and all yes", AndResponseFilter(yes, isOK), true},
+		{"and one no", AndResponseFilter(yes, no), false},
+		{"or none", OrResponseFilter(), false},
+		{"or one yes", OrResponseFilter(no, yes), true},
+		{"or all no", OrResponseFilter(no, no), false},
+		{"not", NotResponseFilter(no), true},
+		{"header filter", AdaptResponseHeaderFilter(NewContentTypeFilter([]string{"text/*"})), false},
+		{"request filter", AdaptRequestFilter(NewPathFilter([]string{"/api/"}, nil)), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.ShouldCompress(req, http.StatusOK, header); got != tt.want {
+				t.Errorf("ShouldCompress() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func contentTypeHeader(contentType string) http.Header {
 	return http.Header{"Content-Type": []string{contentType}}
 }
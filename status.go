@@ -0,0 +1,86 @@
+package gzip
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// StatusCodeRange is an inclusive range of status codes,
+// e.g. StatusCodeRange{500, 599} for all server errors.
+type StatusCodeRange struct {
+	Min int
+	Max int
+}
+
+// isValid tells whether r is a non-empty range of status codes
+func (r StatusCodeRange) isValid() bool {
+	return r.Min >= 100 && r.Min <= r.Max && r.Max <= 999
+}
+
+func (r StatusCodeRange) contains(statusCode int) bool {
+	return statusCode >= r.Min && statusCode <= r.Max
+}
+
+// defaultSkipStatusCodes are responses that never carry a body
+var defaultSkipStatusCodes = []StatusCodeRange{
+	{http.StatusNoContent, http.StatusNoContent},
+	{http.StatusNotModified, http.StatusNotModified},
+}
+
+// DefaultSkipStatusCodes returns the status code ranges
+// skipped by default, i.e. 204 and 304.
+func DefaultSkipStatusCodes() []StatusCodeRange {
+	return append([]StatusCodeRange(nil), defaultSkipStatusCodes...)
+}
+
+// statusCodePolicy decides whether to compress by status code
+type statusCodePolicy struct {
+	// empty means all
+	compress []StatusCodeRange
+	skip     []StatusCodeRange
+}
+
+// newStatusCodePolicy validates ranges,
+// nil skip means defaultSkipStatusCodes.
+func newStatusCodePolicy(compress, skip []StatusCodeRange) statusCodePolicy {
+	if skip == nil {
+		skip = defaultSkipStatusCodes
+	}
+	for _, ranges := range [][]StatusCodeRange{compress, skip} {
+		for _, r := range ranges {
+			if !r.isValid() {
+				panic(fmt.Sprintf("gzip: invalid status code range: %d-%d", r.Min, r.Max))
+			}
+		}
+	}
+
+	return statusCodePolicy{
+		compress: compress,
+		skip:     skip,
+	}
+}
+
+// shouldCompress tells whether response of statusCode may be compressed.
+//
+// 206 is never compressed, since its Content-Range refers to
+// offsets of the uncompressed representation.
+func (p statusCodePolicy) shouldCompress(statusCode int) bool {
+	if statusCode == http.StatusPartialContent {
+		return false
+	}
+	for _, r := range p.skip {
+		if r.contains(statusCode) {
+			return false
+		}
+	}
+	if len(p.compress) == 0 {
+		return true
+	}
+	for _, r := range p.compress {
+		if r.contains(statusCode) {
+			return true
+		}
+	}
+
+	return false
+}
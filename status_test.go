@@ -0,0 +1,41 @@
+package gzip
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_statusCodePolicy_shouldCompress(t *testing.T) {
+	tests := []struct {
+		name       string
+		compress   []StatusCodeRange
+		skip       []StatusCodeRange
+		statusCode int
+		want       bool
+	}{
+		{"default 200", nil, nil, http.StatusOK, true},
+		{"default 204", nil, nil, http.StatusNoContent, false},
+		{"default 304", nil, nil, http.StatusNotModified, false},
+		{"default 500", nil, nil, http.StatusInternalServerError, true},
+		{"206 always", nil, []StatusCodeRange{}, http.StatusPartialContent, false},
+		{"empty skip", nil, []StatusCodeRange{}, http.StatusNotModified, true},
+		{"in compress", []StatusCodeRange{{200, 299}}, nil, http.StatusCreated, true},
+		{"out of compress", []StatusCodeRange{{200, 299}}, nil, http.StatusNotFound, false},
+		{"skip wins", []StatusCodeRange{{200, 599}}, []StatusCodeRange{{500, 599}}, http.StatusBadGateway, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := newStatusCodePolicy(tt.compress, tt.skip)
+			assert.Equal(t, tt.want, policy.shouldCompress(tt.statusCode))
+		})
+	}
+}
+
+func TestDefaultSkipStatusCodes(t *testing.T) {
+	codes := DefaultSkipStatusCodes()
+	codes[0].Min = http.StatusOK
+
+	assert.Equal(t, http.StatusNoContent, defaultSkipStatusCodes[0].Min)
+}
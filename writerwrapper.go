@@ -1,32 +1,111 @@
 package gzip
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"strconv"
-	"strings"
-
-	"github.com/klauspost/compress/gzip"
+	"sync"
+	"time"
 )
 
 // writerWrapper wraps the originalHandler
-// to test whether to gzip and gzip the body if applicable.
+// to test whether to compress and compress the body if applicable.
 type writerWrapper struct {
-	// header filter are applied by its sequence
-	Filters []ResponseHeaderFilter
+	// response filter are applied by its sequence
+	Filters []ResponseFilter
 	// min content length to enable compress
 	MinContentLength int64
-	OriginWriter     http.ResponseWriter
-	// use initGzipWriter() to init gzipWriter when in need
-	GetGzipWriter func() *gzip.Writer
-	// must close gzip writer and put it back to pool
-	PutGzipWriter func(*gzip.Writer)
+	// decides whether to compress by status code
+	StatusCodes  statusCodePolicy
+	OriginWriter http.ResponseWriter
+	// use initEncoder() to init encoder when in need,
+	// streaming tells whether the encoder is for a streaming response
+	GetEncoder func(encoding string, streaming bool) Encoder
+	// must close encoder and put it back to pool
+	PutEncoder func(encoding string, streaming bool, e Encoder)
+	// content-codings of responses matching EncodingOverrides[i]
+	// are decided by overrideEncodings[i]
+	EncodingOverrides []encodingOverride
+	// media types of streaming responses
+	StreamingContentTypes map[string]struct{}
+	// sync flush compressed response after every AutoFlushSize bytes written,
+	// 0 means disabled
+	AutoFlushSize int
+	// sync flush compressed response when data is pending for AutoFlushLatency,
+	// 0 means disabled
+	AutoFlushLatency time.Duration
+	// trailer names of uncompressed size and CRC-32 checksum
+	// of compressed response, empty means disabled
+	SizeTrailer     string
+	ChecksumTrailer string
+	// remove Accept-Ranges from compressed 200 responses
+	StripAcceptRanges bool
+	// ETag of compressed response is decided by ETagPolicy
+	ETagPolicy ETagPolicy
+	// integrity fields of response are dealt with by DigestPolicy
+	DigestPolicy DigestPolicy
+
+	// mu guards writing against auto flushing in background
+	mu sync.Mutex
 
 	// internal below
 	// *** WARNING ***
 	// *writerWrapper.Reset() method must be updated
 	// upon following field changing
 
+	// content-coding of compressed response
+	// default to gzip
+	encoding string
+	// content-codings negotiated for EncodingOverrides
+	overrideEncodings []string
+	// whether response is in StreamingContentTypes
+	streaming bool
+	// whether response is a text/event-stream
+	eventStream bool
+	// last bytes written to text/event-stream
+	eventTail [3]byte
+	// request of the response, which is passed to Filters
+	req *http.Request
+	// context of the request, which bounds auto flushing
+	ctx context.Context
+	// Accept-Encoding of the request, passed on to pushed requests
+	acceptEncoding []string
+	// uncompressed bytes written into encoder since last flush
+	pendingSize int
+	// CRC-32 of uncompressed bytes written into encoder
+	checksum uint32
+	// content-coding with which an ETag of request is decoded,
+	// 304 response is given the ETag encoded with it.
+	etagEncoding string
+	// compressed response is stored into cache with cacheKey,
+	// nil cache means no caching
+	cache       *Cache
+	cacheKey    string
+	cacheTarget string
+	// whether request carries credentials, see Handler.personal()
+	cachePersonal bool
+	// original ETag and header of compressed response
+	cacheETag   string
+	cacheHeader http.Header
+	cacheSink   cacheSink
+	// hashes over compressed body, sent as digestField
+	digestHashes []digestHash
+	// Content-Digest or Repr-Digest
+	digestField string
+	// whether connection is hijacked
+	hijacked bool
+	// close to stop auto flushing
+	autoFlushStop chan struct{}
+	// closed when auto flushing is stopped
+	autoFlushDone chan struct{}
 	// compress or not
 	// default to true
 	shouldCompress bool
@@ -38,29 +117,43 @@ type writerWrapper struct {
 	statusCode            int
 	// how many raw bytes has been written
 	size       int
-	gzipWriter *gzip.Writer
+	encoder    Encoder
 	bodyBuffer []byte
 }
 
 // interface guard
 var _ http.ResponseWriter = (*writerWrapper)(nil)
 var _ http.Flusher = (*writerWrapper)(nil)
-
-func newWriterWrapper(filters []ResponseHeaderFilter, minContentLength int64, originWriter http.ResponseWriter, getGzipWriter func() *gzip.Writer, putGzipWriter func(*gzip.Writer)) *writerWrapper {
+var _ io.StringWriter = (*writerWrapper)(nil)
+var _ io.ReaderFrom = (*writerWrapper)(nil)
+var _ http.Hijacker = hijackerWriterWrapper{}
+var _ http.Pusher = pusherWriterWrapper{}
+var _ http.Hijacker = hijackerPusherWriterWrapper{}
+var _ http.Pusher = hijackerPusherWriterWrapper{}
+
+func newWriterWrapper(filters []ResponseFilter, minContentLength int64, originWriter http.ResponseWriter, getEncoder func(string, bool) Encoder, putEncoder func(string, bool, Encoder)) *writerWrapper {
 	return &writerWrapper{
+		encoding:         encodingGzip,
+		ctx:              context.Background(),
 		shouldCompress:   true,
 		bodyBuffer:       make([]byte, 0, minContentLength),
 		Filters:          filters,
 		MinContentLength: minContentLength,
+		StatusCodes:      newStatusCodePolicy(nil, nil),
 		OriginWriter:     originWriter,
-		GetGzipWriter:    getGzipWriter,
-		PutGzipWriter:    putGzipWriter,
+		GetEncoder:       getEncoder,
+		PutEncoder:       putEncoder,
+		ETagPolicy:       NewWeakETagPolicy(),
 	}
 }
 
 // Reset the wrapper into a fresh one,
-// writing to originWriter
-func (w *writerWrapper) Reset(originWriter http.ResponseWriter) {
+// writing response of req to originWriter in content-coding encoding,
+// or overrideEncodings[i] if response matches EncodingOverrides[i].
+//
+// Empty content-coding means no compression.
+func (w *writerWrapper) Reset(originWriter http.ResponseWriter, req *http.Request, encoding string, overrideEncodings ...string) {
+	w.stopAutoFlush()
 	w.OriginWriter = originWriter
 
 	// internal below
@@ -74,9 +167,34 @@ func (w *writerWrapper) Reset(originWriter http.ResponseWriter) {
 	w.statusCode = 0
 	w.size = 0
 
-	if w.gzipWriter != nil {
-		w.PutGzipWriter(w.gzipWriter)
-		w.gzipWriter = nil
+	if w.encoder != nil {
+		w.PutEncoder(w.encoding, w.streaming, w.encoder)
+		w.encoder = nil
+	}
+	w.encoding = encoding
+	w.overrideEncodings = append(w.overrideEncodings[:0], overrideEncodings...)
+	w.streaming = false
+	w.eventStream = false
+	w.eventTail = [3]byte{}
+	w.pendingSize = 0
+	w.checksum = 0
+	w.etagEncoding = ""
+	w.cache = nil
+	w.cacheKey = ""
+	w.cacheTarget = ""
+	w.cachePersonal = false
+	w.cacheETag = ""
+	w.cacheHeader = nil
+	w.cacheSink.Reset(0)
+	w.digestHashes = nil
+	w.digestField = ""
+	w.hijacked = false
+	w.req = req
+	w.ctx = context.Background()
+	w.acceptEncoding = nil
+	if req != nil {
+		w.ctx = req.Context()
+		w.acceptEncoding = req.Header["Accept-Encoding"]
 	}
 	if w.bodyBuffer != nil {
 		w.bodyBuffer = w.bodyBuffer[:0]
@@ -99,9 +217,18 @@ func (w *writerWrapper) WriteHeaderCalled() bool {
 	return w.statusCode != 0
 }
 
-func (w *writerWrapper) initGzipWriter() {
-	w.gzipWriter = w.GetGzipWriter()
-	w.gzipWriter.Reset(w.OriginWriter)
+func (w *writerWrapper) initEncoder() {
+	w.encoder = w.GetEncoder(w.encoding, w.streaming)
+
+	var target io.Writer = w.OriginWriter
+	if len(w.digestHashes) > 0 {
+		target = teeWriter{w: target, sink: digestWriter(w.digestHashes)}
+	}
+	if w.cache != nil {
+		w.cacheSink.Reset(w.cache.entryLimit())
+		target = teeWriter{w: target, sink: &w.cacheSink}
+	}
+	w.encoder.Reset(target)
 }
 
 // Header implements http.ResponseWriter
@@ -109,8 +236,107 @@ func (w *writerWrapper) Header() http.Header {
 	return w.OriginWriter.Header()
 }
 
+// WriteString implements io.StringWriter
+func (w *writerWrapper) WriteString(s string) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.WriteHeaderCalled() {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if stringWriter, ok := w.OriginWriter.(io.StringWriter); ok && !w.shouldCompress {
+		w.size += len(s)
+		w.WriteHeaderNow()
+		return stringWriter.WriteString(s)
+	}
+
+	return w.write([]byte(s))
+}
+
+// copyBufferSize is the size of buffer used by ReadFrom
+const copyBufferSize = 32 * 1024
+
+var copyBufferPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, copyBufferSize)
+		return &buf
+	},
+}
+
+// ReadFrom implements io.ReaderFrom
+//
+// Once the response is decided not to be compressed,
+// the rest of src is handed to OriginWriter's ReadFrom,
+// keeping fast paths like sendfile available.
+// Otherwise src is streamed through the encoder with a pooled buffer.
+//
+// mu is not held while reading src, which may block,
+// so that auto flushing goes on.
+func (w *writerWrapper) ReadFrom(src io.Reader) (int64, error) {
+	bufPtr := copyBufferPool.Get().(*[]byte)
+	defer copyBufferPool.Put(bufPtr)
+	buf := *bufPtr
+
+	var n int64
+	for {
+		// the decision may be made by the first chunk
+		if readerFrom, ok := w.uncompressedReaderFrom(); ok {
+			written, err := readerFrom.ReadFrom(src)
+			w.mu.Lock()
+			w.size += int(written)
+			w.mu.Unlock()
+			return n + written, err
+		}
+
+		readSize, readErr := src.Read(buf)
+		if readSize > 0 {
+			written, writeErr := w.Write(buf[:readSize])
+			n += int64(written)
+			if writeErr != nil {
+				return n, writeErr
+			}
+			if written != readSize {
+				return n, io.ErrShortWrite
+			}
+		}
+		if readErr == io.EOF {
+			return n, nil
+		}
+		if readErr != nil {
+			return n, readErr
+		}
+	}
+}
+
+// uncompressedReaderFrom returns OriginWriter as an io.ReaderFrom
+// once the response is decided not to be compressed,
+// with header written.
+func (w *writerWrapper) uncompressedReaderFrom() (io.ReaderFrom, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.WriteHeaderCalled() {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.shouldCompress {
+		return nil, false
+	}
+
+	w.WriteHeaderNow()
+	readerFrom, ok := w.OriginWriter.(io.ReaderFrom)
+	return readerFrom, ok
+}
+
 // Write implements http.ResponseWriter
 func (w *writerWrapper) Write(data []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.write(data)
+}
+
+func (w *writerWrapper) write(data []byte) (int, error) {
 	w.size += len(data)
 
 	if !w.WriteHeaderCalled() {
@@ -122,53 +348,197 @@ func (w *writerWrapper) Write(data []byte) (int, error) {
 		return w.OriginWriter.Write(data)
 	}
 	if w.bodyBigEnough {
-		return w.gzipWriter.Write(data)
+		return w.writeEncoder(data)
 	}
 
 	// fast check
 	if !w.responseHeaderChecked {
-		w.responseHeaderChecked = true
-
-		header := w.Header()
-		for _, filter := range w.Filters {
-			w.shouldCompress = filter.ShouldCompress(header)
-			if !w.shouldCompress {
-				w.WriteHeaderNow()
-				return w.OriginWriter.Write(data)
-			}
+		w.checkResponseHeader()
+		if !w.shouldCompress {
+			w.WriteHeaderNow()
+			return w.OriginWriter.Write(data)
 		}
 
-		if w.enoughContentLength() {
-			w.bodyBigEnough = true
-			w.WriteHeaderNow()
-			w.initGzipWriter()
-			return w.gzipWriter.Write(data)
+		// streaming response does not wait for MinContentLength
+		if w.streaming || w.enoughContentLength() {
+			w.startCompression()
+			return w.writeEncoder(data)
 		}
 	}
 
 	if !w.writeBuffer(data) {
-		w.bodyBigEnough = true
-
 		// detect Content-Type if there's none
 		if header := w.Header(); header.Get("Content-Type") == "" {
 			header.Set("Content-Type", http.DetectContentType(w.bodyBuffer))
 		}
 
-		w.WriteHeaderNow()
-		w.initGzipWriter()
-		if len(w.bodyBuffer) > 0 {
-			written, err := w.gzipWriter.Write(w.bodyBuffer)
-			if err != nil {
-				err = fmt.Errorf("w.gzipWriter.Write: %w", err)
-				return written, err
-			}
+		if written, err := w.startCompression(); err != nil {
+			return written, err
 		}
-		return w.gzipWriter.Write(data)
+		return w.writeEncoder(data)
 	}
 
 	return len(data), nil
 }
 
+// checkResponseHeader decides whether to compress by response header
+func (w *writerWrapper) checkResponseHeader() {
+	w.responseHeaderChecked = true
+
+	header := w.Header()
+	for _, filter := range w.Filters {
+		w.shouldCompress = filter.ShouldCompress(w.req, w.statusCode, header)
+		if !w.shouldCompress {
+			return
+		}
+	}
+
+	if w.DigestPolicy.skips(header) {
+		w.shouldCompress = false
+		return
+	}
+
+	mediaType := mediaTypeOf(header.Get("Content-Type"))
+	// offsets of ranges refer to uncompressed body
+	if mediaType == "multipart/byteranges" || header.Get("Content-Range") != "" {
+		w.shouldCompress = false
+		return
+	}
+	w.overrideEncoding(mediaType)
+	if w.encoding == "" {
+		w.shouldCompress = false
+		return
+	}
+
+	_, w.streaming = w.StreamingContentTypes[mediaType]
+	w.eventStream = w.streaming && mediaType == "text/event-stream"
+}
+
+// writeEncoder writes data into encoder,
+// flushing text/event-stream at event boundaries,
+// and every AutoFlushSize bytes
+func (w *writerWrapper) writeEncoder(data []byte) (int, error) {
+	written, err := w.encoder.Write(data)
+	if w.ChecksumTrailer != "" {
+		w.checksum = crc32.Update(w.checksum, crc32.IEEETable, data[:written])
+	}
+	if err != nil {
+		return written, err
+	}
+	w.pendingSize += written
+
+	if (w.eventStream && w.endsEvent(data)) ||
+		(w.AutoFlushSize > 0 && w.pendingSize >= w.AutoFlushSize) {
+		return written, w.flush()
+	}
+
+	return written, nil
+}
+
+// endsEvent tells whether data ends an event of text/event-stream,
+// i.e. the last write ends with a blank line.
+//
+// see https://html.spec.whatwg.org/multipage/server-sent-events.html#event-stream-interpretation
+func (w *writerWrapper) endsEvent(data []byte) bool {
+	tail := w.eventTail[:]
+	if len(data) >= len(tail) {
+		copy(tail, data[len(data)-len(tail):])
+	} else {
+		copy(tail, tail[len(data):])
+		copy(tail[len(tail)-len(data):], data)
+	}
+
+	return bytes.HasSuffix(tail, []byte("\n\n")) ||
+		bytes.HasSuffix(tail, []byte("\n\r\n")) ||
+		bytes.HasSuffix(tail, []byte("\r\r"))
+}
+
+// startCompression flushes header and
+// writes buffered body into a freshly initialized encoder
+func (w *writerWrapper) startCompression() (int, error) {
+	w.bodyBigEnough = true
+
+	w.WriteHeaderNow()
+	w.initEncoder()
+	if w.AutoFlushLatency > 0 {
+		w.startAutoFlush()
+	}
+	if len(w.bodyBuffer) > 0 {
+		written, err := w.encoder.Write(w.bodyBuffer)
+		if w.ChecksumTrailer != "" {
+			w.checksum = crc32.Update(w.checksum, crc32.IEEETable, w.bodyBuffer[:written])
+		}
+		if err != nil {
+			err = fmt.Errorf("w.encoder.Write: %w", err)
+			return written, err
+		}
+		w.pendingSize += written
+	}
+
+	return 0, nil
+}
+
+// startAutoFlush flushes pending data every AutoFlushLatency in background,
+// until the request is done or stopAutoFlush() is called.
+func (w *writerWrapper) startAutoFlush() {
+	var (
+		stop   = make(chan struct{})
+		done   = make(chan struct{})
+		ctx    = w.ctx
+		ticker = time.NewTicker(w.AutoFlushLatency)
+	)
+
+	w.autoFlushStop = stop
+	w.autoFlushDone = done
+
+	go func() {
+		defer close(done)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-stop:
+				return
+			case <-ticker.C:
+				w.mu.Lock()
+				if w.encoder != nil && w.pendingSize > 0 {
+					_ = w.flush()
+				}
+				w.mu.Unlock()
+			}
+		}
+	}()
+}
+
+// stopAutoFlush stops auto flushing and waits for it to quit
+//
+// w.mu must not be held by caller.
+func (w *writerWrapper) stopAutoFlush() {
+	if w.autoFlushStop == nil {
+		return
+	}
+
+	close(w.autoFlushStop)
+	<-w.autoFlushDone
+	w.autoFlushStop = nil
+	w.autoFlushDone = nil
+}
+
+// overrideEncoding switches content-coding if response matches EncodingOverrides
+func (w *writerWrapper) overrideEncoding(mediaType string) {
+	for i := range w.EncodingOverrides {
+		if w.EncodingOverrides[i].matches(mediaType) {
+			w.encoding = ""
+			if i < len(w.overrideEncodings) {
+				w.encoding = w.overrideEncodings[i]
+			}
+			return
+		}
+	}
+}
+
 func (w *writerWrapper) writeBuffer(data []byte) (fit bool) {
 	if int64(len(data)+len(w.bodyBuffer)) > w.MinContentLength {
 		return false
@@ -200,26 +570,40 @@ func (w *writerWrapper) enoughContentLength() bool {
 // conflicting between http and gin's implementation.
 // Here, gzip consider second(and furthermore) calls to WriteHeader()
 // valid. WriteHeader() is disabled after flushing header.
-// Do note setting status code to 204 or 304 marks content uncompressable,
-// and a later status code change does not revert this.
+// Do note setting status code skipped by StatusCodes, e.g. 204 or 304,
+// marks content uncompressable, and a later status code change does not revert this.
 func (w *writerWrapper) WriteHeader(statusCode int) {
 	if w.headerFlushed {
 		return
 	}
 
+	// informational responses like 103 Early Hints are not final,
+	// they go to OriginWriter right away and leave compression alone.
+	if isInformational(statusCode) {
+		w.OriginWriter.WriteHeader(statusCode)
+		return
+	}
+
 	w.statusCode = statusCode
 
 	if !w.shouldCompress {
 		return
 	}
 
-	if statusCode == http.StatusNoContent ||
-		statusCode == http.StatusNotModified {
+	if !w.StatusCodes.shouldCompress(statusCode) {
 		w.shouldCompress = false
 		return
 	}
 }
 
+// isInformational reports whether statusCode is a 1xx response
+// which is followed by a final one.
+//
+// 101 Switching Protocols is excluded since it ends the HTTP exchange.
+func isInformational(statusCode int) bool {
+	return statusCode >= 100 && statusCode <= 199 && statusCode != http.StatusSwitchingProtocols
+}
+
 // WriteHeaderNow Forces to write the http header (status code + headers).
 //
 // WriteHeaderNow must always be called and called after
@@ -241,11 +625,30 @@ func (w *writerWrapper) WriteHeaderNow() {
 	if w.shouldCompress {
 		header := w.Header()
 		header.Del("Content-Length")
-		header.Set("Content-Encoding", "gzip")
+		header.Set("Content-Encoding", w.encoding)
 		header.Add("Vary", "Accept-Encoding")
-		originalEtag := w.Header().Get("ETag")
-		if originalEtag != "" && !strings.HasPrefix(originalEtag, "W/") {
-			w.Header().Set("ETag", "W/"+originalEtag)
+		if etag := header.Get("ETag"); etag != "" {
+			w.cacheETag = etag
+			header.Set("ETag", w.ETagPolicy.Encode(etag, w.encoding))
+		}
+		if w.StripAcceptRanges && w.statusCode == http.StatusOK {
+			header.Del("Accept-Ranges")
+		}
+		if hasDigest(header) {
+			w.prepareDigest(header)
+		}
+		if w.cache != nil {
+			w.cacheHeader = header.Clone()
+		}
+
+		w.declareTrailers(header)
+	}
+
+	// client validated an ETag of compressed representation
+	if w.statusCode == http.StatusNotModified && w.etagEncoding != "" {
+		header := w.Header()
+		if etag := header.Get("ETag"); etag != "" {
+			header.Set("ETag", w.ETagPolicy.Encode(etag, w.etagEncoding))
 		}
 	}
 
@@ -254,11 +657,40 @@ func (w *writerWrapper) WriteHeaderNow() {
 	w.headerFlushed = true
 }
 
-// FinishWriting flushes header and closed gzip writer
+// declareTrailers announces trailers set by FinishWriting()
+// in header of compressed response
+func (w *writerWrapper) declareTrailers(header http.Header) {
+	if len(w.digestHashes) > 0 {
+		header.Add("Trailer", w.digestField)
+	}
+	if w.SizeTrailer != "" {
+		header.Add("Trailer", w.SizeTrailer)
+	}
+	if w.ChecksumTrailer != "" {
+		header.Add("Trailer", w.ChecksumTrailer)
+	}
+}
+
+// FinishWriting flushes header and closed encoder
 //
 // Write() and WriteHeader() should not be called
 // after FinishWriting()
 func (w *writerWrapper) FinishWriting() {
+	w.stopAutoFlush()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// connection is taken over by handler, nothing should be written
+	if w.hijacked {
+		if w.encoder != nil {
+			w.encoder.Reset(ioutil.Discard)
+			w.PutEncoder(w.encoding, w.streaming, w.encoder)
+			w.encoder = nil
+		}
+		return
+	}
+
 	// still buffering
 	if w.shouldCompress && !w.bodyBigEnough {
 		w.shouldCompress = false
@@ -269,17 +701,219 @@ func (w *writerWrapper) FinishWriting() {
 	}
 
 	w.WriteHeaderNow()
-	if w.gzipWriter != nil {
-		w.PutGzipWriter(w.gzipWriter)
-		w.gzipWriter = nil
+	if w.encoder != nil {
+		// trailers of handler are sent by OriginWriter
+		// after the compressed body is finalized here
+		w.PutEncoder(w.encoding, w.streaming, w.encoder)
+		w.encoder = nil
+
+		var digest string
+		if len(w.digestHashes) > 0 {
+			digest = formatContentDigest(w.digestHashes)
+		}
+		if w.cache != nil {
+			if digest != "" && w.cacheHeader != nil {
+				w.cacheHeader.Set(w.digestField, digest)
+			}
+			w.storeCache()
+		}
+
+		header := w.Header()
+		if digest != "" {
+			header.Set(w.digestField, digest)
+		}
+		if w.SizeTrailer != "" {
+			header.Set(w.SizeTrailer, strconv.Itoa(w.size))
+		}
+		if w.ChecksumTrailer != "" {
+			header.Set(w.ChecksumTrailer, fmt.Sprintf("%08x", w.checksum))
+		}
 	}
 }
 
 // Flush implements http.Flusher
+//
+// Flush sends header and any pending data to the client,
+// the compressed stream is sync flushed and kept open
+// for later writes, and is only finalized by FinishWriting().
+//
+// If whether to compress is still undecided on flushing,
+// response is compressed as long as response header filters permit,
+// since MinContentLength can not be judged any more.
 func (w *writerWrapper) Flush() {
-	w.FinishWriting()
+	_ = w.FlushError()
+}
+
+// FlushError is Flush() which reports error,
+// it's preferred by http.ResponseController over Flush().
+func (w *writerWrapper) FlushError() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.flush()
+}
+
+func (w *writerWrapper) flush() error {
+	if !w.WriteHeaderCalled() {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	// still buffering
+	if w.shouldCompress && !w.bodyBigEnough {
+		if !w.responseHeaderChecked {
+			w.checkResponseHeader()
+		}
+
+		if w.shouldCompress {
+			// detect Content-Type if there's none
+			if header := w.Header(); header.Get("Content-Type") == "" && len(w.bodyBuffer) > 0 {
+				header.Set("Content-Type", http.DetectContentType(w.bodyBuffer))
+			}
+			_, _ = w.startCompression()
+		} else {
+			w.WriteHeaderNow()
+			if len(w.bodyBuffer) > 0 {
+				_, _ = w.OriginWriter.Write(w.bodyBuffer)
+			}
+		}
+	}
 
-	if flusher, ok := w.OriginWriter.(http.Flusher); ok {
+	w.WriteHeaderNow()
+	if w.encoder != nil {
+		if err := w.encoder.Flush(); err != nil {
+			return fmt.Errorf("w.encoder.Flush: %w", err)
+		}
+		w.pendingSize = 0
+	}
+
+	return flushError(w.OriginWriter)
+}
+
+// flushError flushes writer if it's a flusher,
+// preferring FlushError() which reports error.
+func flushError(writer http.ResponseWriter) error {
+	switch flusher := writer.(type) {
+	case interface{ FlushError() error }:
+		return flusher.FlushError()
+	case http.Flusher:
 		flusher.Flush()
 	}
+
+	return nil
+}
+
+// Unwrap returns the original http.ResponseWriter,
+// so that http.ResponseController can reach
+// its SetReadDeadline, SetWriteDeadline, EnableFullDuplex, etc.
+//
+// Flush of http.ResponseController still goes through FlushError().
+func (w *writerWrapper) Unwrap() http.ResponseWriter {
+	return w.OriginWriter
+}
+
+// withOptionalInterfaces returns w as an http.ResponseWriter,
+// which implements http.Hijacker and http.Pusher
+// exactly when OriginWriter does.
+func (w *writerWrapper) withOptionalInterfaces() http.ResponseWriter {
+	_, isHijacker := w.OriginWriter.(http.Hijacker)
+	_, isPusher := w.OriginWriter.(http.Pusher)
+
+	switch {
+	case isHijacker && isPusher:
+		return hijackerPusherWriterWrapper{w}
+	case isHijacker:
+		return hijackerWriterWrapper{w}
+	case isPusher:
+		return pusherWriterWrapper{w}
+	default:
+		return w
+	}
+}
+
+// hijack takes over the connection of OriginWriter,
+// after which the wrapper writes nothing.
+func (w *writerWrapper) hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	hijacker, ok := w.OriginWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("gzip: original http.ResponseWriter is not a http.Hijacker")
+	}
+
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return conn, rw, err
+	}
+	w.hijacked = true
+
+	return conn, rw, nil
+}
+
+// push initiates an HTTP/2 server push via OriginWriter
+func (w *writerWrapper) push(target string, opts *http.PushOptions) error {
+	pusher, ok := w.OriginWriter.(http.Pusher)
+	if !ok {
+		return http.ErrNotSupported
+	}
+
+	return w.pushVia(pusher, target, opts)
+}
+
+// pushVia initiates an HTTP/2 server push via pusher.
+//
+// The pushed request carries the Accept-Encoding of current request
+// unless opts says otherwise, so that it negotiates content-coding
+// the same way when it goes through Handler.
+func (w *writerWrapper) pushVia(pusher http.Pusher, target string, opts *http.PushOptions) error {
+	if len(w.acceptEncoding) == 0 {
+		return pusher.Push(target, opts)
+	}
+
+	pushOptions := http.PushOptions{}
+	if opts != nil {
+		pushOptions = *opts
+	}
+	if _, ok := pushOptions.Header["Accept-Encoding"]; !ok {
+		header := make(http.Header, len(pushOptions.Header)+1)
+		for key, values := range pushOptions.Header {
+			header[key] = values
+		}
+		header["Accept-Encoding"] = append([]string(nil), w.acceptEncoding...)
+		pushOptions.Header = header
+	}
+
+	return pusher.Push(target, &pushOptions)
+}
+
+type hijackerWriterWrapper struct {
+	*writerWrapper
+}
+
+// Hijack implements http.Hijacker
+func (w hijackerWriterWrapper) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return w.hijack()
+}
+
+type pusherWriterWrapper struct {
+	*writerWrapper
+}
+
+// Push implements http.Pusher
+func (w pusherWriterWrapper) Push(target string, opts *http.PushOptions) error {
+	return w.push(target, opts)
+}
+
+type hijackerPusherWriterWrapper struct {
+	*writerWrapper
+}
+
+// Hijack implements http.Hijacker
+func (w hijackerPusherWriterWrapper) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return w.hijack()
+}
+
+// Push implements http.Pusher
+func (w hijackerPusherWriterWrapper) Push(target string, opts *http.PushOptions) error {
+	return w.push(target, opts)
 }
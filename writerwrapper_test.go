@@ -1,14 +1,24 @@
 package gzip
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"strconv"
 	"sync"
 	"testing"
+	"testing/iotest"
+	"time"
 
+	"github.com/andybalholm/brotli"
 	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -28,18 +38,44 @@ var gzipWriterPool = sync.Pool{
 		return gzip.NewWriter(ioutil.Discard)
 	}}
 
-func getGzipWriter() *gzip.Writer {
-	return gzipWriterPool.Get().(*gzip.Writer)
+var brotliWriterPool = sync.Pool{
+	New: func() interface{} {
+		return brotli.NewWriter(ioutil.Discard)
+	}}
+
+var zstdWriterPool = sync.Pool{
+	New: func() interface{} {
+		writer, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		return writer
+	}}
+
+func getEncoder(encoding string, _ bool) Encoder {
+	switch encoding {
+	case encodingZstd:
+		return zstdWriterPool.Get().(*zstd.Encoder)
+	case encodingBrotli:
+		return brotliWriterPool.Get().(*brotli.Writer)
+	default:
+		return gzipWriterPool.Get().(*gzip.Writer)
+	}
 }
 
-func putGzipWriter(w *gzip.Writer) {
+func putEncoder(encoding string, _ bool, w Encoder) {
 	if w == nil {
 		return
 	}
 
 	_ = w.Close()
 	w.Reset(ioutil.Discard)
-	gzipWriterPool.Put(w)
+
+	switch encoding {
+	case encodingZstd:
+		zstdWriterPool.Put(w)
+	case encodingBrotli:
+		brotliWriterPool.Put(w)
+	default:
+		gzipWriterPool.Put(w)
+	}
 }
 
 type DummyResFilter bool
@@ -51,11 +87,11 @@ func (d DummyResFilter) ShouldCompress(_ http.Header) bool {
 func newWrapper(filters ...ResponseHeaderFilter) (*writerWrapper, *httptest.ResponseRecorder) {
 	recorder := httptest.NewRecorder()
 	return newWriterWrapper(
-		filters,
+		responseFilters(filters, nil),
 		minContentLength,
 		recorder,
-		getGzipWriter,
-		putGzipWriter,
+		getEncoder,
+		putEncoder,
 	), recorder
 }
 
@@ -65,13 +101,560 @@ func Test_writerWrapper_Flush(t *testing.T) {
 	assert.True(t, recorder.Flushed)
 }
 
+func Test_writerWrapper_Flush_keeps_compressing(t *testing.T) {
+	wrapper, recorder := newWrapper()
+
+	_, err := wrapper.Write(smallPayload)
+	require.NoError(t, err)
+	wrapper.Flush()
+
+	result := recorder.Result()
+	assert.True(t, recorder.Flushed)
+	assert.EqualValues(t, http.StatusOK, result.StatusCode)
+	assert.Equal(t, "gzip", result.Header.Get("Content-Encoding"))
+
+	// what's flushed so far is decodable
+	reader, err := gzip.NewReader(bytes.NewReader(recorder.Body.Bytes()))
+	require.NoError(t, err)
+	flushed := make([]byte, len(smallPayload))
+	_, err = io.ReadFull(reader, flushed)
+	require.NoError(t, err)
+	assert.Equal(t, smallPayload, flushed)
+
+	_, err = wrapper.Write(bigPayload)
+	require.NoError(t, err)
+	wrapper.Flush()
+	_, err = wrapper.Write(smallPayload)
+	require.NoError(t, err)
+	wrapper.FinishWriting()
+
+	reader, err = gzip.NewReader(bytes.NewReader(recorder.Body.Bytes()))
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, string(smallPayload)+string(bigPayload)+string(smallPayload), string(body))
+}
+
+func Test_writerWrapper_Write_event_stream(t *testing.T) {
+	wrapper, recorder := newWrapper()
+	wrapper.StreamingContentTypes = map[string]struct{}{"text/event-stream": {}}
+	wrapper.Header().Set("Content-Type", "text/event-stream")
+
+	// small event is compressed and flushed right away
+	_, err := wrapper.Write([]byte("data: hello\n\n"))
+	require.NoError(t, err)
+	assert.True(t, wrapper.streaming)
+	assert.True(t, wrapper.bodyBigEnough)
+	assert.True(t, recorder.Flushed)
+	assert.Equal(t, "gzip", recorder.Header().Get("Content-Encoding"))
+
+	reader, err := gzip.NewReader(bytes.NewReader(recorder.Body.Bytes()))
+	require.NoError(t, err)
+	flushed := make([]byte, len("data: hello\n\n"))
+	_, err = io.ReadFull(reader, flushed)
+	require.NoError(t, err)
+	assert.Equal(t, "data: hello\n\n", string(flushed))
+
+	// event written in parts is flushed at its end
+	recorder.Flushed = false
+	_, err = wrapper.Write([]byte("data: world\n"))
+	require.NoError(t, err)
+	assert.False(t, recorder.Flushed)
+	_, err = wrapper.Write([]byte("\n"))
+	require.NoError(t, err)
+	assert.True(t, recorder.Flushed)
+
+	wrapper.FinishWriting()
+
+	reader, err = gzip.NewReader(bytes.NewReader(recorder.Body.Bytes()))
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "data: hello\n\ndata: world\n\n", string(body))
+}
+
+func Test_writerWrapper_Write_streaming_not_event_stream(t *testing.T) {
+	wrapper, recorder := newWrapper()
+	wrapper.StreamingContentTypes = map[string]struct{}{"application/x-ndjson": {}}
+	wrapper.Header().Set("Content-Type", "application/x-ndjson")
+
+	_, err := wrapper.Write([]byte("{}\n\n"))
+	require.NoError(t, err)
+	assert.True(t, wrapper.streaming)
+	assert.False(t, wrapper.eventStream)
+	assert.True(t, wrapper.bodyBigEnough)
+	assert.False(t, recorder.Flushed)
+
+	wrapper.Flush()
+	assert.True(t, recorder.Flushed)
+	wrapper.FinishWriting()
+
+	reader, err := gzip.NewReader(bytes.NewReader(recorder.Body.Bytes()))
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "{}\n\n", string(body))
+}
+
+func Test_writerWrapper_endsEvent(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		want   bool
+	}{
+		{"LF", []string{"data: 1\n\n"}, true},
+		{"CRLF", []string{"data: 1\r\n\r\n"}, true},
+		{"CR", []string{"data: 1\r\r"}, true},
+		{"mixed", []string{"data: 1\n\r\n"}, true},
+		{"line only", []string{"data: 1\n"}, false},
+		{"split", []string{"data: 1\n", "\n"}, true},
+		{"split CRLF", []string{"data: 1\r\n", "\r", "\n"}, true},
+		{"split line", []string{"data: 1\n", "data: 2\n"}, false},
+		{"empty", []string{""}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wrapper, _ := newWrapper()
+
+			var got bool
+			for _, item := range tt.writes {
+				got = wrapper.endsEvent([]byte(item))
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_writerWrapper_AutoFlushSize(t *testing.T) {
+	const partial = 10
+
+	wrapper, recorder := newWrapper()
+	wrapper.AutoFlushSize = 3 * partial
+	recorder.Header().Set("Content-Length", strconv.Itoa(len(bigPayload)))
+
+	for i := 0; i < 3; i++ {
+		assert.False(t, recorder.Flushed)
+		_, err := wrapper.Write(bigPayload[i*partial : (i+1)*partial])
+		require.NoError(t, err)
+	}
+	assert.True(t, recorder.Flushed)
+	assert.EqualValues(t, 0, wrapper.pendingSize)
+
+	reader, err := gzip.NewReader(bytes.NewReader(recorder.Body.Bytes()))
+	require.NoError(t, err)
+	flushed := make([]byte, 3*partial)
+	_, err = io.ReadFull(reader, flushed)
+	require.NoError(t, err)
+	assert.Equal(t, bigPayload[:3*partial], flushed)
+
+	_, err = wrapper.Write(bigPayload[3*partial:])
+	require.NoError(t, err)
+	wrapper.FinishWriting()
+
+	reader, err = gzip.NewReader(bytes.NewReader(recorder.Body.Bytes()))
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, bigPayload, body)
+}
+
+func Test_writerWrapper_AutoFlushLatency(t *testing.T) {
+	wrapper, recorder := newWrapper()
+	wrapper.AutoFlushLatency = 10 * time.Millisecond
+	wrapper.Reset(recorder, httptest.NewRequest(http.MethodGet, "/", nil), encodingGzip)
+
+	_, err := wrapper.Write(bigPayload)
+	require.NoError(t, err)
+
+	flushed := func() bool {
+		wrapper.mu.Lock()
+		defer wrapper.mu.Unlock()
+
+		return recorder.Flushed
+	}
+	assert.Eventually(t, flushed, time.Second, time.Millisecond)
+
+	wrapper.FinishWriting()
+	assert.Nil(t, wrapper.autoFlushStop)
+
+	reader, err := gzip.NewReader(bytes.NewReader(recorder.Body.Bytes()))
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, bigPayload, body)
+}
+
+// slowReader reads chunks one by one, sleeping before each
+type slowReader struct {
+	chunks [][]byte
+	delay  time.Duration
+}
+
+func (s *slowReader) Read(p []byte) (int, error) {
+	if len(s.chunks) == 0 {
+		return 0, io.EOF
+	}
+	time.Sleep(s.delay)
+	n := copy(p, s.chunks[0])
+	s.chunks[0] = s.chunks[0][n:]
+	if len(s.chunks[0]) == 0 {
+		s.chunks = s.chunks[1:]
+	}
+	return n, nil
+}
+
+func Test_writerWrapper_AutoFlushLatency_ReadFrom(t *testing.T) {
+	wrapper, recorder := newWrapper()
+	wrapper.AutoFlushLatency = 10 * time.Millisecond
+	wrapper.Reset(recorder, httptest.NewRequest(http.MethodGet, "/", nil), encodingGzip)
+
+	src := &slowReader{delay: 100 * time.Millisecond}
+	for i := 0; i < 5; i++ {
+		src.chunks = append(src.chunks, bigPayload)
+	}
+
+	flushedDuringCopy := make(chan bool, 1)
+	go func() {
+		flushed := func() bool {
+			wrapper.mu.Lock()
+			defer wrapper.mu.Unlock()
+
+			return recorder.Flushed
+		}
+		flushedDuringCopy <- assert.Eventually(t, flushed, 300*time.Millisecond, time.Millisecond)
+	}()
+
+	n, err := io.Copy(wrapper, src)
+	require.NoError(t, err)
+	assert.EqualValues(t, 5*len(bigPayload), n)
+	assert.True(t, <-flushedDuringCopy)
+	wrapper.FinishWriting()
+
+	reader, err := gzip.NewReader(bytes.NewReader(recorder.Body.Bytes()))
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, bytes.Repeat(bigPayload, 5), body)
+}
+
+func Test_writerWrapper_AutoFlushLatency_request_done(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	wrapper, recorder := newWrapper()
+	wrapper.AutoFlushLatency = time.Hour
+	wrapper.Reset(recorder, httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx), encodingGzip)
+
+	_, err := wrapper.Write(bigPayload)
+	require.NoError(t, err)
+	done := wrapper.autoFlushDone
+	require.NotNil(t, done)
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("auto flushing does not stop after request is done")
+	}
+
+	wrapper.FinishWriting()
+}
+
+func Test_writerWrapper_Unwrap(t *testing.T) {
+	wrapper, recorder := newWrapper()
+
+	assert.Equal(t, recorder, wrapper.Unwrap())
+}
+
+func Test_writerWrapper_ResponseController_Flush(t *testing.T) {
+	wrapper, recorder := newWrapper()
+	controller := http.NewResponseController(wrapper)
+
+	_, err := wrapper.Write(smallPayload)
+	require.NoError(t, err)
+	require.NoError(t, controller.Flush())
+	assert.True(t, recorder.Flushed)
+	assert.True(t, wrapper.bodyBigEnough)
+	assert.Equal(t, "gzip", recorder.Header().Get("Content-Encoding"))
+
+	_, err = wrapper.Write(smallPayload)
+	require.NoError(t, err)
+	wrapper.FinishWriting()
+
+	reader, err := gzip.NewReader(bytes.NewReader(recorder.Body.Bytes()))
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, string(smallPayload)+string(smallPayload), string(body))
+}
+
+type hijackerRecorder struct {
+	*httptest.ResponseRecorder
+	hijacked bool
+}
+
+func (h *hijackerRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h.hijacked = true
+	return nil, nil, nil
+}
+
+type pusherRecorder struct {
+	*httptest.ResponseRecorder
+	pushed      []string
+	pushOptions []*http.PushOptions
+}
+
+func (p *pusherRecorder) Push(target string, opts *http.PushOptions) error {
+	p.pushed = append(p.pushed, target)
+	p.pushOptions = append(p.pushOptions, opts)
+	return nil
+}
+
+type hijackerPusherRecorder struct {
+	hijackerRecorder
+	pusherRecorder
+}
+
+func (h *hijackerPusherRecorder) Header() http.Header {
+	return h.hijackerRecorder.Header()
+}
+
+func (h *hijackerPusherRecorder) Write(data []byte) (int, error) {
+	return h.hijackerRecorder.Write(data)
+}
+
+func (h *hijackerPusherRecorder) WriteHeader(statusCode int) {
+	h.hijackerRecorder.WriteHeader(statusCode)
+}
+
+func Test_writerWrapper_withOptionalInterfaces(t *testing.T) {
+	tests := []struct {
+		name         string
+		originWriter http.ResponseWriter
+		wantHijacker bool
+		wantPusher   bool
+	}{
+		{
+			name:         "plain",
+			originWriter: httptest.NewRecorder(),
+		},
+		{
+			name:         "hijacker",
+			originWriter: &hijackerRecorder{ResponseRecorder: httptest.NewRecorder()},
+			wantHijacker: true,
+		},
+		{
+			name:         "pusher",
+			originWriter: &pusherRecorder{ResponseRecorder: httptest.NewRecorder()},
+			wantPusher:   true,
+		},
+		{
+			name: "hijacker and pusher",
+			originWriter: &hijackerPusherRecorder{
+				hijackerRecorder: hijackerRecorder{ResponseRecorder: httptest.NewRecorder()},
+				pusherRecorder:   pusherRecorder{ResponseRecorder: httptest.NewRecorder()},
+			},
+			wantHijacker: true,
+			wantPusher:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wrapper := newWriterWrapper(nil, minContentLength, tt.originWriter, getEncoder, putEncoder)
+			w := wrapper.withOptionalInterfaces()
+
+			_, isHijacker := w.(http.Hijacker)
+			_, isPusher := w.(http.Pusher)
+			assert.Equal(t, tt.wantHijacker, isHijacker)
+			assert.Equal(t, tt.wantPusher, isPusher)
+			assert.Implements(t, (*http.Flusher)(nil), w)
+			assert.Implements(t, (*io.StringWriter)(nil), w)
+			assert.Implements(t, (*io.ReaderFrom)(nil), w)
+		})
+	}
+}
+
+func Test_writerWrapper_Push(t *testing.T) {
+	recorder := &pusherRecorder{ResponseRecorder: httptest.NewRecorder()}
+	wrapper := newWriterWrapper(nil, minContentLength, recorder, getEncoder, putEncoder)
+
+	pusher, ok := wrapper.withOptionalInterfaces().(http.Pusher)
+	require.True(t, ok)
+	require.NoError(t, pusher.Push("/style.css", nil))
+	assert.Equal(t, []string{"/style.css"}, recorder.pushed)
+}
+
+func Test_writerWrapper_Push_accept_encoding(t *testing.T) {
+	recorder := &pusherRecorder{ResponseRecorder: httptest.NewRecorder()}
+	wrapper := newWriterWrapper(nil, minContentLength, recorder, getEncoder, putEncoder)
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "br, gzip")
+	wrapper.Reset(recorder, req, "br")
+
+	pusher, ok := wrapper.withOptionalInterfaces().(http.Pusher)
+	require.True(t, ok)
+
+	require.NoError(t, pusher.Push("/style.css", nil))
+	opts := &http.PushOptions{Header: http.Header{"X-Foo": []string{"bar"}}}
+	require.NoError(t, pusher.Push("/app.js", opts))
+	require.NoError(t, pusher.Push("/raw.txt", &http.PushOptions{Header: http.Header{"Accept-Encoding": []string{"identity"}}}))
+
+	require.Len(t, recorder.pushOptions, 3)
+	assert.Equal(t, "br, gzip", recorder.pushOptions[0].Header.Get("Accept-Encoding"))
+	assert.Equal(t, "br, gzip", recorder.pushOptions[1].Header.Get("Accept-Encoding"))
+	assert.Equal(t, "bar", recorder.pushOptions[1].Header.Get("X-Foo"))
+	assert.Equal(t, "identity", recorder.pushOptions[2].Header.Get("Accept-Encoding"))
+	// options of caller are left untouched
+	assert.Empty(t, opts.Header.Get("Accept-Encoding"))
+}
+
+func Test_writerWrapper_Hijack(t *testing.T) {
+	recorder := &hijackerRecorder{ResponseRecorder: httptest.NewRecorder()}
+	wrapper := newWriterWrapper(nil, minContentLength, recorder, getEncoder, putEncoder)
+
+	_, err := wrapper.Write(bigPayload)
+	require.NoError(t, err)
+
+	hijacker, ok := wrapper.withOptionalInterfaces().(http.Hijacker)
+	require.True(t, ok)
+	_, _, err = hijacker.Hijack()
+	require.NoError(t, err)
+	assert.True(t, recorder.hijacked)
+
+	// nothing more to write after hijacking
+	written := recorder.Body.Len()
+	wrapper.FinishWriting()
+	assert.Equal(t, written, recorder.Body.Len())
+}
+
+func Test_writerWrapper_Hijack_not_supported(t *testing.T) {
+	wrapper, _ := newWrapper()
+
+	_, _, err := wrapper.hijack()
+	assert.Error(t, err)
+	assert.Equal(t, http.ErrNotSupported, wrapper.push("/style.css", nil))
+}
+
+func Test_writerWrapper_WriteString(t *testing.T) {
+	wrapper, recorder := newWrapper()
+
+	_, err := wrapper.WriteString(string(bigPayload))
+	require.NoError(t, err)
+	wrapper.FinishWriting()
+
+	assert.Equal(t, "gzip", recorder.Header().Get("Content-Encoding"))
+	reader, err := gzip.NewReader(bytes.NewReader(recorder.Body.Bytes()))
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, bigPayload, body)
+}
+
+func Test_writerWrapper_WriteString_filter_no(t *testing.T) {
+	wrapper, recorder := newWrapper(DummyResFilter(false))
+
+	_, err := wrapper.Write(smallPayload)
+	require.NoError(t, err)
+	_, err = wrapper.WriteString(string(bigPayload))
+	require.NoError(t, err)
+	wrapper.FinishWriting()
+
+	assert.Empty(t, recorder.Header().Get("Content-Encoding"))
+	assert.Equal(t, string(smallPayload)+string(bigPayload), recorder.Body.String())
+}
+
+// readerFromRecorder records whether its ReadFrom is called
+type readerFromRecorder struct {
+	*httptest.ResponseRecorder
+	readFrom bool
+}
+
+func (r *readerFromRecorder) ReadFrom(src io.Reader) (int64, error) {
+	r.readFrom = true
+	return io.Copy(r.ResponseRecorder, src)
+}
+
+func Test_writerWrapper_ReadFrom(t *testing.T) {
+	recorder := &readerFromRecorder{ResponseRecorder: httptest.NewRecorder()}
+	wrapper := newWriterWrapper(nil, minContentLength, recorder, getEncoder, putEncoder)
+
+	n, err := wrapper.ReadFrom(bytes.NewReader(bigPayload))
+	require.NoError(t, err)
+	assert.EqualValues(t, len(bigPayload), n)
+	wrapper.FinishWriting()
+
+	assert.False(t, recorder.readFrom)
+	assert.Equal(t, "gzip", recorder.Header().Get("Content-Encoding"))
+	reader, err := gzip.NewReader(bytes.NewReader(recorder.Body.Bytes()))
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, bigPayload, body)
+}
+
+func Test_writerWrapper_ReadFrom_filter_no(t *testing.T) {
+	recorder := &readerFromRecorder{ResponseRecorder: httptest.NewRecorder()}
+	wrapper := newWriterWrapper([]ResponseFilter{AdaptResponseHeaderFilter(DummyResFilter(false))}, minContentLength, recorder, getEncoder, putEncoder)
+
+	_, err := wrapper.Write(smallPayload)
+	require.NoError(t, err)
+	n, err := wrapper.ReadFrom(bytes.NewReader(bigPayload))
+	require.NoError(t, err)
+	assert.EqualValues(t, len(bigPayload), n)
+	wrapper.FinishWriting()
+
+	assert.True(t, recorder.readFrom)
+	assert.Empty(t, recorder.Header().Get("Content-Encoding"))
+	assert.Equal(t, len(smallPayload)+len(bigPayload), wrapper.size)
+	assert.Equal(t, string(smallPayload)+string(bigPayload), recorder.Body.String())
+}
+
+func Test_writerWrapper_ReadFrom_decided_by_first_chunk(t *testing.T) {
+	recorder := &readerFromRecorder{ResponseRecorder: httptest.NewRecorder()}
+	wrapper := newWriterWrapper([]ResponseFilter{AdaptResponseHeaderFilter(DummyResFilter(false))}, minContentLength, recorder, getEncoder, putEncoder)
+
+	n, err := wrapper.ReadFrom(iotest.OneByteReader(bytes.NewReader(bigPayload)))
+	require.NoError(t, err)
+	assert.EqualValues(t, len(bigPayload), n)
+	wrapper.FinishWriting()
+
+	assert.True(t, recorder.readFrom)
+	assert.Empty(t, recorder.Header().Get("Content-Encoding"))
+	assert.Equal(t, len(bigPayload), wrapper.size)
+	assert.Equal(t, bigPayload, recorder.Body.Bytes())
+}
+
+func Test_writerWrapper_ReadFrom_read_error(t *testing.T) {
+	wrapper, _ := newWrapper()
+	readErr := errors.New("read error")
+
+	n, err := wrapper.ReadFrom(io.MultiReader(bytes.NewReader(smallPayload), iotest.ErrReader(readErr)))
+	assert.Equal(t, readErr, err)
+	assert.EqualValues(t, len(smallPayload), n)
+}
+
+func Test_writerWrapper_Flush_filter_no(t *testing.T) {
+	wrapper, recorder := newWrapper(DummyResFilter(false))
+
+	_, err := wrapper.Write(smallPayload)
+	require.NoError(t, err)
+	wrapper.Flush()
+	_, err = wrapper.Write(bigPayload)
+	require.NoError(t, err)
+	wrapper.FinishWriting()
+
+	result := recorder.Result()
+	assert.True(t, recorder.Flushed)
+	assert.False(t, wrapper.shouldCompress)
+	assert.Empty(t, result.Header.Get("Content-Encoding"))
+	assert.Equal(t, string(smallPayload)+string(bigPayload), recorder.Body.String())
+}
+
 func TestNewWriterWrapper_ShouldCompress_True(t *testing.T) {
 	wrapper := newWriterWrapper(
 		nil,
 		minContentLength,
 		nil,
-		getGzipWriter,
-		putGzipWriter,
+		getEncoder,
+		putEncoder,
 	)
 
 	assert.True(t, wrapper.shouldCompress)
@@ -93,6 +676,70 @@ func Test_writerWrapper_Header(t *testing.T) {
 	assert.Equal(t, value, wrapper.Header().Get(key))
 }
 
+// statusRecorder records every status code written
+type statusRecorder struct {
+	*httptest.ResponseRecorder
+	statusCodes []int
+}
+
+func (s *statusRecorder) WriteHeader(statusCode int) {
+	s.statusCodes = append(s.statusCodes, statusCode)
+	if statusCode >= 200 {
+		s.ResponseRecorder.WriteHeader(statusCode)
+	}
+}
+
+func Test_writerWrapper_WriteHeader_informational(t *testing.T) {
+	tests := []struct {
+		name            string
+		finalStatusCode int
+		wantEncoding    string
+	}{
+		{
+			name:            "200",
+			finalStatusCode: http.StatusOK,
+			wantEncoding:    "gzip",
+		},
+		{
+			name:            "204",
+			finalStatusCode: http.StatusNoContent,
+			wantEncoding:    "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := &statusRecorder{ResponseRecorder: httptest.NewRecorder()}
+			wrapper := newWriterWrapper(nil, minContentLength, recorder, getEncoder, putEncoder)
+
+			wrapper.Header().Set("Link", "</style.css>; rel=preload; as=style")
+			wrapper.WriteHeader(http.StatusEarlyHints)
+			assert.False(t, wrapper.WriteHeaderCalled())
+			assert.True(t, wrapper.shouldCompress)
+			assert.Equal(t, []int{http.StatusEarlyHints}, recorder.statusCodes)
+
+			wrapper.WriteHeader(tt.finalStatusCode)
+			if tt.finalStatusCode != http.StatusNoContent {
+				_, err := wrapper.Write(bigPayload)
+				require.NoError(t, err)
+			}
+			wrapper.FinishWriting()
+
+			assert.Equal(t, []int{http.StatusEarlyHints, tt.finalStatusCode}, recorder.statusCodes)
+			assert.Equal(t, tt.finalStatusCode, recorder.Code)
+			assert.Equal(t, tt.wantEncoding, recorder.Header().Get("Content-Encoding"))
+		})
+	}
+}
+
+func Test_writerWrapper_WriteHeader_switching_protocols(t *testing.T) {
+	recorder := &statusRecorder{ResponseRecorder: httptest.NewRecorder()}
+	wrapper := newWriterWrapper(nil, minContentLength, recorder, getEncoder, putEncoder)
+
+	wrapper.WriteHeader(http.StatusSwitchingProtocols)
+	assert.Equal(t, http.StatusSwitchingProtocols, wrapper.Status())
+	assert.Empty(t, recorder.statusCodes)
+}
+
 func Test_writerWrapper_WriteHeader_Twice(t *testing.T) {
 	wrapper, recorder := newWrapper()
 
@@ -206,7 +853,7 @@ func Test_writerWrapper_Write_big_part_by_part_and_reset(t *testing.T) {
 	assert.EqualValues(t, minContentLength, cap(wrapper.bodyBuffer))
 	assert.EqualValues(t, partial, len(wrapper.bodyBuffer))
 
-	wrapper.Reset(nil)
+	wrapper.Reset(nil, nil, encodingGzip)
 	assert.EqualValues(t, minContentLength, cap(wrapper.bodyBuffer))
 	assert.EqualValues(t, 0, len(wrapper.bodyBuffer))
 	assert.EqualValues(t, wrapper.Status(), 0)
@@ -333,6 +980,49 @@ func Test_writerWrapper_Write_small_with_bigContentLength(t *testing.T) {
 	assert.Equal(t, "W/12345", result.Header.Get("ETag"))
 }
 
+func Test_writerWrapper_Write_big_brotli(t *testing.T) {
+	require.Greater(t, len(bigPayload), minContentLength)
+
+	wrapper, recorder := newWrapper()
+	wrapper.Reset(recorder, nil, encodingBrotli)
+
+	_, err := wrapper.Write(bigPayload)
+	assert.NoError(t, err)
+	wrapper.FinishWriting()
+
+	result := recorder.Result()
+	assert.EqualValues(t, http.StatusOK, result.StatusCode)
+	assert.Equal(t, "br", result.Header.Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", result.Header.Get("Vary"))
+
+	body, err := ioutil.ReadAll(brotli.NewReader(result.Body))
+	assert.NoError(t, err)
+	assert.Equal(t, bigPayload, body)
+}
+
+func Test_writerWrapper_Write_big_zstd(t *testing.T) {
+	require.Greater(t, len(bigPayload), minContentLength)
+
+	wrapper, recorder := newWrapper()
+	wrapper.Reset(recorder, nil, encodingZstd)
+
+	_, err := wrapper.Write(bigPayload)
+	assert.NoError(t, err)
+	wrapper.FinishWriting()
+
+	result := recorder.Result()
+	assert.EqualValues(t, http.StatusOK, result.StatusCode)
+	assert.Equal(t, "zstd", result.Header.Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", result.Header.Get("Vary"))
+
+	reader, err := zstd.NewReader(result.Body)
+	require.NoError(t, err)
+	defer reader.Close()
+	body, err := ioutil.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, bigPayload, body)
+}
+
 func Test_writerWrapper_Write_content_type_sniff(t *testing.T) {
 	assert.Greater(t, len(bigPayload), minContentLength)
 